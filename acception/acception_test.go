@@ -27,7 +27,7 @@ func TestMain(m *testing.M) {
 	flag.StringVar(&url, "url", "https://hashworks.net/", "The url to check")
 	flag.StringVar(&screenshotPath, "screenshotPath", "", "Path to the screenshot file generated on failure, tmp file will be used otherwise")
 	flag.StringVar(&htmlPath, "htmlPath", "", "Path to the html file generated on failure, tmp file will be used otherwise")
-	flag.Parse
+	flag.Parse()
 
 	if !strings.HasSuffix(url, "/") {
 		url += "/"
@@ -167,7 +167,7 @@ func TestAcception(t *testing.T) {
 	// STATUS PAGE CHECK
 	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-	var statusBackgroundImages []string
+	var statusImages []string
 
 	// Click status, should navigate to /status and title should be /status as well
 	if !assert.NoError(t, page.FindByLink("status").Click()) {
@@ -239,19 +239,28 @@ func TestAcception(t *testing.T) {
 			}
 			assert.Equal(t, 3, serviceStatusDivsCount)
 		} else if i == 0 {
-			class := "load"
 			assert.Equal(t, articleHeader, "Server Load")
 			assert.Equal(t, "HIVE", articleTag)
 
-			backgroundImage, err := article.FindByXPath(fmt.Sprintf("div[@class='status-svg']/div[@class='%s']", class)).CSS("background-image")
+			chartImage := article.FindByXPath("figure[@class='chart']/img")
+			src, err := chartImage.Attribute("src")
 			if !assert.NoError(t, err) {
 				t.FailNow()
 			}
-			if !assert.True(t, strings.HasPrefix(backgroundImage, `url("`)) {
-				t.FailNow()
+			alt, err := chartImage.Attribute("alt")
+			if assert.NoError(t, err) {
+				assert.True(t, strings.HasPrefix(alt, "Load"), alt)
+			}
+			if strings.HasPrefix(src, "/") {
+				src = strings.TrimSuffix(url, "/") + src
 			}
+			statusImages = append(statusImages, src)
 
-			statusBackgroundImages = append(statusBackgroundImages, strings.Split(backgroundImage, `"`)[1])
+			// The data table of the chart, a header and at least one row
+			dataRows, err := article.AllByXPath("figure[@class='chart']/details/table[@class='data']//tr").Count()
+			if assert.NoError(t, err) {
+				assert.True(t, dataRows >= 2)
+			}
 		} else {
 			t.FailNow()
 		}
@@ -262,7 +271,7 @@ func TestAcception(t *testing.T) {
 	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 	// Check status graphs
-	for _, link := range statusBackgroundImages {
+	for _, link := range statusImages {
 		resp, err := http.Get(link)
 		if assert.NoError(t, err) {
 			defer resp.Body.Close()
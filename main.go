@@ -8,7 +8,9 @@ package main
 import (
 	"embed"
 	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hashworks/hashworksNET/server"
@@ -33,9 +35,18 @@ func main() {
 	app.Version = fmt.Sprintf("%s (%s)", VERSION, BUILD_DATE)
 	app.Copyright = "GNU General Public License v3.0"
 
-	config := server.Config{GinMode: GIN_MODE, Version: VERSION, BuildDate: BUILD_DATE, StaticContent: staticContent}
+	config := server.Config{GinMode: GIN_MODE, Version: VERSION, BuildDate: BUILD_DATE, StaticContent: staticContent, Prometheus: server.DefaultPrometheusConfig()}
+
+	var configPath string
 
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			EnvVar:      "HWNET_CONFIG",
+			Name:        "config",
+			Usage:       "path to the YAML configuration file listing the monitored nodes",
+			Value:       "",
+			Destination: &configPath,
+		},
 		cli.BoolFlag{
 			EnvVar:      "HWNET_DEBUG",
 			Name:        "debug",
@@ -52,9 +63,58 @@ func main() {
 		cli.StringFlag{
 			EnvVar: "HWNET_ADDRESS",
 			Name:   "address",
-			Usage:  "address to listen on",
+			Usage:  "address to listen on, like 127.0.0.1:65432 or unix:/run/hashworksNET/http.sock",
 			Value:  "127.0.0.1:65432",
 		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_METRICS_ADDRESS",
+			Name:        "metricsAddress",
+			Usage:       "address to serve /metrics on, served with the other routes if empty",
+			Value:       "",
+			Destination: &config.MetricsAddress,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_TLS_CERT",
+			Name:        "tlsCert",
+			Usage:       "path of the PEM certificate chain to serve HTTPS with, reloaded on changes and SIGUSR1",
+			Value:       "",
+			Destination: &config.TLSCert,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_TLS_KEY",
+			Name:        "tlsKey",
+			Usage:       "path of the PEM key of the certificate",
+			Value:       "",
+			Destination: &config.TLSKey,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_TLS_OCSP_STAPLE",
+			Name:        "tlsOCSPStaple",
+			Usage:       "path of a DER OCSP response to staple, reloaded on changes",
+			Value:       "",
+			Destination: &config.TLSOCSPStaple,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_TLS_REDIRECT_ADDRESS",
+			Name:        "tlsRedirectAddress",
+			Usage:       "address to redirect HTTP requests to HTTPS on, like :80",
+			Value:       "",
+			Destination: &config.TLSRedirectAddress,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_SOCKET_MODE",
+			Name:        "socketMode",
+			Usage:       "octal permissions of unix sockets",
+			Value:       "0660",
+			Destination: &config.SocketMode,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_SOCKET_GROUP",
+			Name:        "socketGroup",
+			Usage:       "group name or ID of unix sockets, like the one of the proxy",
+			Value:       "",
+			Destination: &config.SocketGroup,
+		},
 		cli.BoolFlag{
 			EnvVar:      "HWNET_TLS_PROXY",
 			Name:        "tlsProxy",
@@ -68,6 +128,48 @@ func main() {
 			Value:       "127.0.0.1",
 			Destination: &config.TrustedProxy,
 		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_STATE_DIR",
+			Name:        "stateDir",
+			Usage:       "directory to store the status history in, disabled if empty",
+			Value:       "",
+			Destination: &config.StateDir,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_ADMIN_TOKEN",
+			Name:        "adminToken",
+			Usage:       "bearer token of the admin endpoints, disabled if empty",
+			Value:       "",
+			Destination: &config.AdminToken,
+		},
+		cli.StringFlag{
+			EnvVar:      "HWNET_LOG_OUTPUT",
+			Name:        "logOutput",
+			Usage:       "where to write the JSON log to: stderr, journald or the path of a file",
+			Value:       server.LogOutputStderr,
+			Destination: &config.LogOutput,
+		},
+		cli.IntFlag{
+			EnvVar:      "HWNET_LOG_MAX_SIZE",
+			Name:        "logMaxSize",
+			Usage:       "size in MiB after which the log file is rotated",
+			Value:       100,
+			Destination: &config.LogMaxSize,
+		},
+		cli.IntFlag{
+			EnvVar:      "HWNET_LOG_MAX_BACKUPS",
+			Name:        "logMaxBackups",
+			Usage:       "number of rotated log files to keep",
+			Value:       5,
+			Destination: &config.LogMaxBackups,
+		},
+		cli.DurationFlag{
+			EnvVar:      "HWNET_SHUTDOWN_TIMEOUT",
+			Name:        "shutdownTimeout",
+			Usage:       "how long running requests may take to finish on SIGTERM or SIGHUP",
+			Value:       30 * time.Second,
+			Destination: &config.ShutdownTimeout,
+		},
 		cli.BoolFlag{
 			EnvVar:      "HWNET_GZIP",
 			Name:        "gzip",
@@ -77,14 +179,18 @@ func main() {
 	}
 
 	app.Action = func(cli *cli.Context) error {
+		if configPath != "" {
+			if err := config.LoadFile(configPath); err != nil {
+				return err
+			}
+		}
 		s, err := server.NewServer(config)
 		if err != nil {
 			return err
 		}
-		if err := s.Router.Run(cli.String("address")); err != nil {
-			return err
-		}
-		return nil
+		// Remaining log.Printf calls end up in the same log
+		slog.SetDefault(s.Logger())
+		return s.ListenAndServe(cli.String("address"))
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-errors/errors"
+)
+
+const (
+	AnnouncementTypeIncident    = "incident"
+	AnnouncementTypeMaintenance = "maintenance"
+)
+
+// announcementTimeFormat is used for the periods shown on the status page
+const announcementTimeFormat = "2006-01-02 15:04 MST"
+
+// Announcement is an incident or a maintenance window shown above the nodes.
+// Services affected by an active maintenance are shown as "maintenance"
+// instead of "error".
+type Announcement struct {
+	ID      string `yaml:"-" json:"id"`
+	Type    string `yaml:"type" json:"type"`
+	Title   string `yaml:"title" json:"title"`
+	Message string `yaml:"message" json:"message"`
+	// A zero start is active immediately, a zero end until removed
+	Start time.Time `yaml:"start" json:"start"`
+	End   time.Time `yaml:"end" json:"end"`
+	// Either "node" for all services of a node or "node/service"
+	Affects []string `yaml:"affects" json:"affects"`
+}
+
+func (a Announcement) active(now time.Time) bool {
+	return !now.Before(a.Start) && (a.End.IsZero() || now.Before(a.End))
+}
+
+// affects reports whether the announcement covers a service of a node
+func (a Announcement) affects(node, service string) bool {
+	for _, affected := range a.Affects {
+		if affected == node || affected == node+"/"+service {
+			return true
+		}
+	}
+	return false
+}
+
+// Period describes when the announcement is active
+func (a Announcement) Period() string {
+	switch {
+	case a.Start.After(time.Now()) && a.End.IsZero():
+		return "Scheduled from " + a.Start.Format(announcementTimeFormat) + "."
+	case a.Start.After(time.Now()):
+		return "Scheduled from " + a.Start.Format(announcementTimeFormat) + " until " + a.End.Format(announcementTimeFormat) + "."
+	case !a.End.IsZero():
+		return "Until " + a.End.Format(announcementTimeFormat) + "."
+	case !a.Start.IsZero():
+		return "Since " + a.Start.Format(announcementTimeFormat) + "."
+	}
+	return ""
+}
+
+func (a *Announcement) validate(nodes []NodeConfig) error {
+	switch a.Type {
+	case AnnouncementTypeIncident, AnnouncementTypeMaintenance:
+	default:
+		return fmt.Errorf("announcement '%s' has unknown type '%s'", a.Title, a.Type)
+	}
+	if a.Title == "" {
+		return errors.New("announcement has no title")
+	}
+	if !a.End.IsZero() && !a.End.After(a.Start) {
+		return fmt.Errorf("announcement '%s' ends before it starts", a.Title)
+	}
+
+	for _, affected := range a.Affects {
+		nodeName, serviceName, hasService := strings.Cut(affected, "/")
+		found := false
+		for _, node := range nodes {
+			if node.Name != nodeName {
+				continue
+			}
+			if !hasService {
+				found = true
+			}
+			for _, service := range node.Services {
+				if service.Name == serviceName {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("announcement '%s' affects unknown service '%s'", a.Title, affected)
+		}
+	}
+
+	return nil
+}
+
+// announcements holds the announcements of the configuration file and those
+// created with the admin endpoint. The latter are stored in the state
+// directory, if set.
+type announcements struct {
+	sync.RWMutex
+	static  []Announcement
+	dynamic []Announcement
+	// Empty if the dynamic announcements aren't persisted
+	path string
+}
+
+func newAnnouncements(config Config) (*announcements, error) {
+	a := &announcements{}
+	for i, announcement := range config.Announcements {
+		announcement.ID = fmt.Sprintf("config-%d", i)
+		a.static = append(a.static, announcement)
+	}
+
+	if config.StateDir == "" {
+		return a, nil
+	}
+	a.path = filepath.Join(config.StateDir, "announcements.json")
+
+	data, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &a.dynamic); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", a.path, err)
+	}
+
+	return a, nil
+}
+
+// all returns every announcement, ordered by start
+func (a *announcements) all() []Announcement {
+	a.RLock()
+	defer a.RUnlock()
+
+	all := append(append([]Announcement{}, a.static...), a.dynamic...)
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Start.Before(all[j].Start)
+	})
+	return all
+}
+
+// current returns the active announcements and the upcoming maintenance windows
+func (a *announcements) current(now time.Time) []Announcement {
+	var current []Announcement
+	for _, announcement := range a.all() {
+		if announcement.active(now) || (announcement.Type == AnnouncementTypeMaintenance && announcement.Start.After(now)) {
+			current = append(current, announcement)
+		}
+	}
+	return current
+}
+
+// maintenance returns the active maintenance window of a service, if any
+func (a *announcements) maintenance(node, service string, now time.Time) (Announcement, bool) {
+	for _, announcement := range a.all() {
+		if announcement.Type == AnnouncementTypeMaintenance && announcement.active(now) && announcement.affects(node, service) {
+			return announcement, true
+		}
+	}
+	return Announcement{}, false
+}
+
+// add stores a new announcement and removes expired ones
+func (a *announcements) add(announcement Announcement) (Announcement, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Announcement{}, err
+	}
+	announcement.ID = hex.EncodeToString(id)
+
+	a.Lock()
+	defer a.Unlock()
+
+	var dynamic []Announcement
+	for _, existing := range a.dynamic {
+		if existing.End.IsZero() || existing.End.After(time.Now()) {
+			dynamic = append(dynamic, existing)
+		}
+	}
+	if err := a.save(append(dynamic, announcement)); err != nil {
+		return Announcement{}, err
+	}
+
+	return announcement, nil
+}
+
+// remove deletes an announcement created with the admin endpoint
+func (a *announcements) remove(id string) (bool, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	for i, existing := range a.dynamic {
+		if existing.ID == id {
+			return true, a.save(append(append([]Announcement{}, a.dynamic[:i]...), a.dynamic[i+1:]...))
+		}
+	}
+	return false, nil
+}
+
+// save replaces the dynamic announcements, the lock must be held
+func (a *announcements) save(dynamic []Announcement) error {
+	if a.path != "" {
+		data, err := json.Marshal(dynamic)
+		if err != nil {
+			return err
+		}
+		// Write to a temporary file first, so a crash won't leave a broken file
+		if err := os.WriteFile(a.path+".tmp", data, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(a.path+".tmp", a.path); err != nil {
+			return err
+		}
+	}
+
+	a.dynamic = dynamic
+	return nil
+}
+
+// adminAuthHandler requires the admin token as a bearer token, the scheme is
+// case-insensitive
+func (s Server) adminAuthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			errorJSON(c, http.StatusUnauthorized, errors.New("Invalid admin token."))
+		}
+	}
+}
+
+func (s *Server) handlerAdminAnnouncements(c *gin.Context) {
+	c.JSON(http.StatusOK, s.announcements.all())
+}
+
+func (s *Server) handlerAdminAnnouncementCreate(c *gin.Context) {
+	var announcement Announcement
+	if err := c.ShouldBindJSON(&announcement); err != nil {
+		errorJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	if announcement.Start.IsZero() {
+		announcement.Start = time.Now()
+	}
+	if err := announcement.validate(s.config.Nodes); err != nil {
+		errorJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	announcement, err := s.announcements.add(announcement)
+	if err != nil {
+		s.recoveryHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, announcement)
+}
+
+func (s *Server) handlerAdminAnnouncementDelete(c *gin.Context) {
+	removed, err := s.announcements.remove(c.Param("id"))
+	if err != nil {
+		s.recoveryHandler(c, err)
+		return
+	}
+	if !removed {
+		if strings.HasPrefix(c.Param("id"), "config-") {
+			errorJSON(c, http.StatusForbidden, errors.New("Announcements of the configuration file can't be removed."))
+		} else {
+			errorJSON(c, http.StatusNotFound, errors.New("Unknown announcement."))
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnouncements(t *testing.T) {
+	prometheus, _ := newPrometheusTestConfig(t, func(metric string) string {
+		if metric == "probe_success" {
+			return "0"
+		}
+		return "1"
+	})
+
+	end := time.Now().Add(time.Hour)
+	config := Config{
+		StateDir:   t.TempDir(),
+		AdminToken: "secret",
+		Prometheus: prometheus,
+		Nodes: []NodeConfig{{
+			Name: "hive",
+			FQDN: "hive.example.com",
+			Services: []ServiceConfig{
+				{Name: "Plex", Type: ServiceTypeProbe, Instance: "plex.example.com:32400"},
+				{Name: "DNS", Type: ServiceTypeProbe, Instance: "dns.example.com:853"},
+			},
+		}},
+		Announcements: []Announcement{
+			{Type: AnnouncementTypeMaintenance, Title: "Plex upgrade", Start: time.Now().Add(-time.Hour), End: end, Affects: []string{"hive/Plex"}},
+			{Type: AnnouncementTypeMaintenance, Title: "Next upgrade", Start: time.Now().Add(24 * time.Hour), Affects: []string{"hive"}},
+			{Type: AnnouncementTypeIncident, Title: "Resolved", Start: time.Now().Add(-2 * time.Hour), End: time.Now().Add(-time.Hour)},
+		},
+	}
+
+	s := newTestServer(t, config)
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("GET", "/status", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "<h1>Plex upgrade</h1>")
+	assert.Contains(t, body, "<h1>Next upgrade</h1>")
+	assert.NotContains(t, body, "Resolved")
+	assert.Contains(t, body, `<div class="status maintenance">Planned maintenance until `+end.Format(announcementTimeFormat)+`.</div>`)
+	assert.Contains(t, body, `<div class="status error">Offline.</div>`)
+
+	for _, test := range []struct {
+		name, authorization string
+		code                int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"no scheme", "secret", http.StatusUnauthorized},
+		{"basic scheme", "Basic secret", http.StatusUnauthorized},
+		{"two spaces", "Bearer  secret", http.StatusUnauthorized},
+		{"lowercase scheme", "bearer secret", http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/admin/announcements", nil)
+			req.Header.Set("Authorization", test.authorization)
+			s.Router.ServeHTTP(w, req)
+			assert.Equal(t, test.code, w.Code)
+		})
+	}
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/admin/announcements", "secret", `{"type":"incident"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/admin/announcements", "secret", `{"type":"incident","title":"a","affects":["helios"]}`).Code)
+
+	w = request("POST", "/admin/announcements", "secret", `{"type":"incident","title":"Upstream issues","message":"Packet loss."}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created Announcement
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+
+	w = request("GET", "/admin/announcements", "secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var all []Announcement
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &all))
+	assert.Len(t, all, 4)
+
+	body = request("GET", "/status", "", "").Body.String()
+	assert.Contains(t, body, "<h1>Upstream issues</h1>")
+	assert.Contains(t, body, "<p>Packet loss.</p>")
+
+	// Created announcements are kept in the state directory
+	restored, err := newAnnouncements(config)
+	assert.NoError(t, err)
+	assert.Len(t, restored.all(), 4)
+
+	assert.Equal(t, http.StatusForbidden, request("DELETE", "/admin/announcements/config-0", "secret", "").Code)
+	assert.Equal(t, http.StatusNoContent, request("DELETE", "/admin/announcements/"+created.ID, "secret", "").Code)
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/admin/announcements/"+created.ID, "secret", "").Code)
+	assert.NotContains(t, request("GET", "/status", "", "").Body.String(), "Upstream issues")
+}
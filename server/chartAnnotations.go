@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/hashworks/go-chart"
+)
+
+// chartAnnotations returns the series drawn behind and in front of the series
+// of a chart of a single node: intervals in which probes of the node failed,
+// lines at the thresholds of the metric and markers of deploys and reboots.
+// They are optional, failed queries are left out.
+func (s *Server) chartAnnotations(ctx context.Context, metric chartMetric, nodeConfig NodeConfig, capacity, max float64, start, end time.Time, step time.Duration) (background, foreground []chart.Series) {
+	if failures := s.probeFailures(ctx, nodeConfig, start, end, step); len(failures) > 0 {
+		outage := chart.TimeSeries{
+			Style: chart.Style{
+				Show:        true,
+				ClassName:   "outage",
+				StrokeColor: chartStatusColors["error"].WithAlpha(0),
+				FillColor:   chartStatusColors["error"].WithAlpha(chartOutageFillAlpha),
+			},
+		}
+		for _, failure := range failures {
+			outage.XValues = append(outage.XValues, failure.Time)
+			outage.YValues = append(outage.YValues, failure.Value*max)
+		}
+		background = append(background, outage)
+	}
+
+	for _, threshold := range []struct {
+		status string
+		value  float64
+	}{{"warning", metric.Warning}, {"error", metric.Error}} {
+		value := threshold.value
+		if capacity > 0 {
+			value *= capacity
+		}
+		// Lines above the chart aren't reached
+		if threshold.value == 0 || value > max {
+			continue
+		}
+		foreground = append(foreground, chart.TimeSeries{
+			Style: chart.Style{
+				Show:            true,
+				ClassName:       "threshold " + threshold.status,
+				StrokeColor:     chartStatusColors[threshold.status],
+				StrokeWidth:     1,
+				StrokeDashArray: []float64{6, 4},
+			},
+			XValues: []time.Time{start, end},
+			YValues: []float64{value, value},
+		})
+	}
+
+	for _, marker := range s.chartMarkers(ctx, nodeConfig, start, end, step) {
+		foreground = append(foreground, chart.TimeSeries{
+			Name: marker.Name,
+			Style: chart.Style{
+				Show:            true,
+				ClassName:       "marker " + marker.Type,
+				StrokeColor:     chartMarkerColors[marker.Type],
+				StrokeWidth:     1,
+				StrokeDashArray: []float64{2, 2},
+			},
+			XValues: []time.Time{marker.Time, marker.Time},
+			YValues: []float64{0, max},
+		})
+	}
+
+	return background, foreground
+}
+
+// probeFailures returns 1 at the points in time any probe of a node failed
+// and 0 at the others, nothing if none failed
+func (s *Server) probeFailures(ctx context.Context, nodeConfig NodeConfig, start, end time.Time, step time.Duration) []Sample {
+	source := s.metricsSource(nodeConfig)
+
+	failed := make(map[int64]bool)
+	for _, serviceConfig := range nodeConfig.Services {
+		if serviceConfig.Type != ServiceTypeProbe {
+			continue
+		}
+		samples, err := source.Range(ctx, MetricQuery{Metric: "probe_success", Labels: map[string]string{"instance": serviceConfig.Instance}}, start, end, step)
+		if err != nil {
+			continue
+		}
+		for _, sample := range samples {
+			failed[sample.Time.Unix()] = failed[sample.Time.Unix()] || sample.Value == 0
+		}
+	}
+
+	var failures []Sample
+	anyFailed := false
+	for timestamp, f := range failed {
+		t := time.Unix(timestamp, 0)
+		if t.Before(start) || t.After(end) {
+			continue
+		}
+		value := 0.0
+		if f {
+			value = 1
+			anyFailed = true
+		}
+		failures = append(failures, Sample{t, value})
+	}
+	if !anyFailed {
+		return nil
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Time.Before(failures[j].Time)
+	})
+	return failures
+}
+
+type chartMarker struct {
+	// Shown in the legend, empty for all but the first marker of a type
+	Name string
+	Type string
+	Time time.Time
+}
+
+// chartMarkers returns the start of this server and the reboots of a node in
+// the time range
+func (s *Server) chartMarkers(ctx context.Context, nodeConfig NodeConfig, start, end time.Time, step time.Duration) []chartMarker {
+	var markers []chartMarker
+	inRange := func(t time.Time) bool {
+		return !t.Before(start) && !t.After(end)
+	}
+
+	if inRange(s.startTime) {
+		markers = append(markers, chartMarker{"Deploy", "deploy", s.startTime})
+	}
+
+	samples, err := s.metricsSource(nodeConfig).Range(ctx, MetricQuery{Metric: "node_boot_time_seconds", Labels: map[string]string{"fqdn": nodeConfig.FQDN}}, start, end, step)
+	if err != nil {
+		return markers
+	}
+	name := "Reboot"
+	seen := make(map[int64]bool)
+	for _, sample := range samples {
+		// The boot time jitters by a few seconds with some kernels
+		bootTime := time.Unix(int64(sample.Value), 0).Truncate(time.Minute)
+		if seen[bootTime.Unix()] || !inRange(bootTime) {
+			continue
+		}
+		seen[bootTime.Unix()] = true
+		markers = append(markers, chartMarker{name, "reboot", bootTime})
+		name = ""
+	}
+
+	return markers
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/go-chart"
+)
+
+// chartSummary are statistics of the samples of a series
+type chartSummary struct {
+	Name    string  `json:"name"`
+	Min     float64 `json:"min"`
+	Mean    float64 `json:"mean"`
+	Max     float64 `json:"max"`
+	P95     float64 `json:"p95"`
+	Last    float64 `json:"last"`
+	Samples int     `json:"samples"`
+}
+
+// apiChartSummary is the response of the .json suffix of a chart
+type apiChartSummary struct {
+	Version int            `json:"version"`
+	Range   string         `json:"range"`
+	Time    time.Time      `json:"time"`
+	Series  []chartSummary `json:"series"`
+}
+
+// summarize computes the statistics of samples, which must not be empty
+func summarize(name string, samples []Sample) chartSummary {
+	values := make([]float64, len(samples))
+	var sum float64
+	for i, sample := range samples {
+		values[i] = sample.Value
+		sum += sample.Value
+	}
+	sort.Float64s(values)
+
+	return chartSummary{
+		Name:    name,
+		Min:     values[0],
+		Mean:    sum / float64(len(values)),
+		Max:     values[len(values)-1],
+		P95:     values[int(math.Ceil(0.95*float64(len(values))))-1], // Nearest rank
+		Last:    samples[len(samples)-1].Value,
+		Samples: len(samples),
+	}
+}
+
+func (cs chartSummary) caption(formatter chart.ValueFormatter) string {
+	return fmt.Sprintf("%s: min %s, mean %s, max %s, p95 %s, last %s",
+		cs.Name, formatter(cs.Min), formatter(cs.Mean), formatter(cs.Max), formatter(cs.P95), formatter(cs.Last))
+}
+
+// chartCaption draws a line of text per caption below the chart
+func chartCaption(graph *chart.Chart, captions []string) chart.Renderable {
+	return func(r chart.Renderer, _ chart.Box, defaults chart.Style) {
+		chart.Style{
+			ClassName: "caption",
+			FontColor: chartTextColor,
+			FontSize:  10.0,
+		}.InheritFrom(defaults).GetTextOptions().WriteToRenderer(r)
+
+		for i, caption := range captions {
+			r.Text(caption, chart.DefaultBackgroundPadding.Left, graph.Height-(len(captions)-1-i)*chartCaptionLineHeight-chart.DefaultBackgroundPadding.Bottom)
+		}
+	}
+}
+
+func (s *Server) chartSummaryJSON(c *gin.Context, timeRange chartRange, summaries []chartSummary) {
+	if summaries == nil {
+		summaries = []chartSummary{}
+	}
+
+	c.Header("Cache-Control", "max-age=600")
+	c.Header("Last-Modified", time.Now().Format(time.RFC1123))
+	c.JSON(http.StatusOK, apiChartSummary{
+		Version: apiVersion,
+		Range:   timeRange.Name,
+		Time:    time.Now(),
+		Series:  summaries,
+	})
+}
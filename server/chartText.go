@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chartTableRows is the number of rows the data table of a chart is
+// downsampled to
+const chartTableRows = 12
+
+// ChartText is the text alternative of a chart for screen readers and text
+// browsers
+type ChartText struct {
+	URL string
+	// Summarizes the trend of every series
+	Alt string
+	// The names of the series, the columns of the data table after the time
+	Columns []string
+	Rows    []ChartTableRow
+}
+
+type ChartTableRow struct {
+	Time   string
+	Values []string
+}
+
+// chartText describes a chart of a node and downsamples its series to a table
+func (s *Server) chartText(ctx context.Context, metric chartMetric, nodeConfig NodeConfig, timeRange chartRange) ChartText {
+	text := ChartText{
+		Alt: fmt.Sprintf("%s of %s in the last %s: no data.", metric.Title, nodeConfig.Name, timeRange.Label),
+	}
+
+	series, err := metric.nodeSeries(nodeConfig, nil)
+	if err != nil {
+		return text
+	}
+	samples, _, err := s.chartSamples(ctx, series, timeRange)
+	if err != nil {
+		return text
+	}
+
+	var descriptions []string
+	var first, last time.Time
+	for i, seriesSamples := range samples {
+		if len(seriesSamples) < 2 {
+			continue
+		}
+		description := describeTrend(seriesSamples, metric.Formatter)
+		if len(series) > 1 {
+			description = series[i].Name + " " + description
+		}
+		descriptions = append(descriptions, description)
+		text.Columns = append(text.Columns, series[i].Name)
+
+		if first.IsZero() || seriesSamples[0].Time.Before(first) {
+			first = seriesSamples[0].Time
+		}
+		if seriesSamples[len(seriesSamples)-1].Time.After(last) {
+			last = seriesSamples[len(seriesSamples)-1].Time
+		}
+	}
+	if len(descriptions) == 0 {
+		return text
+	}
+	text.Alt = fmt.Sprintf("%s of %s in the last %s: %s.", metric.Title, nodeConfig.Name, timeRange.Label, strings.Join(descriptions, "; "))
+
+	// Labels of days alone are too coarse for the rows
+	timeFormat := timeRange.TimeFormat
+	if timeRange.Duration > 24*time.Hour {
+		timeFormat = "Jan 2 15:04"
+	}
+
+	bucket := last.Sub(first) / chartTableRows
+	for row := 0; row < chartTableRows; row++ {
+		start := first.Add(bucket * time.Duration(row))
+		end := start.Add(bucket)
+		tableRow := ChartTableRow{Time: start.Format(timeFormat)}
+		empty := true
+		for _, seriesSamples := range samples {
+			if len(seriesSamples) < 2 {
+				continue
+			}
+			var sum float64
+			var count int
+			for _, sample := range seriesSamples {
+				// The last bucket includes the last sample
+				if !sample.Time.Before(start) && (sample.Time.Before(end) || row == chartTableRows-1) {
+					sum += sample.Value
+					count++
+				}
+			}
+			if count == 0 {
+				tableRow.Values = append(tableRow.Values, "")
+				continue
+			}
+			tableRow.Values = append(tableRow.Values, metric.Formatter(sum/float64(count)))
+			empty = false
+		}
+		if !empty {
+			text.Rows = append(text.Rows, tableRow)
+		}
+	}
+
+	return text
+}
+
+// describeTrend compares the first and the last third of samples, like
+// "rising from 0.50 to 1.20, between 0.40 and 1.30"
+func describeTrend(samples []Sample, formatter func(interface{}) string) string {
+	third := len(samples) / 3
+	if third == 0 {
+		third = 1
+	}
+	mean := func(samples []Sample) float64 {
+		var sum float64
+		for _, sample := range samples {
+			sum += sample.Value
+		}
+		return sum / float64(len(samples))
+	}
+	summary := summarize("", samples)
+	before, after := mean(samples[:third]), mean(samples[len(samples)-third:])
+
+	// Changes below a tenth of the largest value are noise
+	trend := fmt.Sprintf("steady around %s", formatter(summary.Mean))
+	if math.Abs(after-before) > 0.1*math.Max(math.Abs(summary.Min), math.Abs(summary.Max)) {
+		direction := "rising"
+		if after < before {
+			direction = "falling"
+		}
+		trend = fmt.Sprintf("%s from %s to %s", direction, formatter(before), formatter(after))
+	}
+
+	return fmt.Sprintf("%s, between %s and %s", trend, formatter(summary.Min), formatter(summary.Max))
+}
+
+// chartTextExpiry is how long the text of a chart is kept before the
+// collector queries it again. Longer ranges barely change within it.
+const chartTextExpiry = 10 * time.Minute
+
+// loadChartTexts returns the text alternatives of the load charts of all
+// nodes by range and node. Texts without data aren't kept, the next
+// collection queries them again.
+func (s *Server) loadChartTexts(ctx context.Context) map[string]map[string]ChartText {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	texts := make(map[string]map[string]ChartText)
+	for _, timeRange := range chartRanges {
+		texts[timeRange.Name] = make(map[string]ChartText)
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, timeRange := range chartRanges {
+		for _, nodeConfig := range s.config.Nodes {
+			wg.Add(1)
+			go func(timeRange chartRange, nodeConfig NodeConfig) {
+				defer wg.Done()
+
+				url := "/load-" + nodeConfig.Name + ".svg"
+				if timeRange.Name != chartRanges[0].Name {
+					url += "?range=" + timeRange.Name
+				}
+
+				var text ChartText
+				if s.config.Debug || !s.cacheGet("chartText", url, &text) {
+					text = s.chartText(ctx, chartMetrics["load"], nodeConfig, timeRange)
+					if len(text.Rows) > 0 {
+						s.cacheSet("chartText", url, text, chartTextExpiry)
+					}
+				}
+				text.URL = url
+
+				mutex.Lock()
+				texts[timeRange.Name][nodeConfig.Name] = text
+				mutex.Unlock()
+			}(timeRange, nodeConfig)
+		}
+	}
+	wg.Wait()
+	return texts
+}
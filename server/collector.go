@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// statusSnapshot is the state of all nodes at a point in time
+type statusSnapshot struct {
+	Nodes []Node
+	// Text alternatives of the load charts by range and node
+	Charts map[string]map[string]ChartText
+	// Sparklines of the services by "node/service"
+	Sparklines map[string]Sparkline
+	Time       time.Time
+}
+
+// statusCollector refreshes the status snapshot in the background, so
+// visitors never wait for the metrics sources
+type statusCollector struct {
+	sync.RWMutex
+	snapshot statusSnapshot
+	// Closed after the first snapshot was collected
+	ready chan struct{}
+	// Closed after the collector stopped, replaced if it runs again
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+func (s *Server) startCollector() {
+	s.collector = &statusCollector{ready: make(chan struct{})}
+	s.runCollector()
+}
+
+// runCollector collects the status in the background until Close
+func (s *Server) runCollector() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.collector.Lock()
+	s.collector.done, s.collector.cancel = done, cancel
+	s.collector.Unlock()
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(s.config.StatusInterval)
+		defer ticker.Stop()
+
+		s.collect(ctx)
+		// Only the first snapshot is waited for, Close may stop it
+		select {
+		case <-s.collector.ready:
+		case <-ctx.Done():
+		default:
+			close(s.collector.ready)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.collect(ctx)
+			}
+		}
+	}()
+}
+
+func (s *Server) collect(ctx context.Context) {
+	var snapshot statusSnapshot
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		snapshot.Nodes = s.queryNodes(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		snapshot.Charts = s.loadChartTexts(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		snapshot.Sparklines = s.serviceSparklines(ctx)
+	}()
+	wg.Wait()
+	// Stopped by Close, the failed queries aren't the fault of the sources
+	if ctx.Err() != nil {
+		return
+	}
+	snapshot.Time = time.Now()
+
+	if s.history != nil {
+		s.recordHistory(snapshot.Nodes, snapshot.Time)
+	}
+
+	s.collector.Lock()
+	defer s.collector.Unlock()
+	s.collector.snapshot = snapshot
+}
+
+// statusSnapshot returns the latest snapshot, waiting for the first one to be
+// collected if necessary. ok is false if ctx is done before that.
+func (s *Server) statusSnapshot(ctx context.Context) (snapshot statusSnapshot, ok bool) {
+	select {
+	case <-s.collector.ready:
+	case <-ctx.Done():
+		return statusSnapshot{}, false
+	}
+
+	s.collector.RLock()
+	defer s.collector.RUnlock()
+	return s.collector.snapshot, true
+}
+
+// Close stops the background status collection and closes the history
+func (s Server) Close() error {
+	s.collector.RLock()
+	cancel, done := s.collector.cancel, s.collector.done
+	s.collector.RUnlock()
+	cancel()
+	<-done
+
+	if s.history != nil {
+		return s.history.close()
+	}
+	return nil
+}
+
+// reopen opens the history and starts the status collection again after
+// Close. The collection runs even if the history fails to open.
+func (s Server) reopen() error {
+	var err error
+	if s.history != nil {
+		err = s.history.open()
+	}
+	s.runCollector()
+	return err
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusCollector(t *testing.T) {
+	load := int32(1)
+	prometheus, queries := newPrometheusTestConfig(t, func(metric string) string {
+		if metric == "node_load1" {
+			return fmt.Sprint(atomic.LoadInt32(&load))
+		}
+		return "0"
+	})
+
+	s := newTestServer(t, Config{
+		Debug:          true,
+		StatusInterval: 50 * time.Millisecond,
+		Prometheus:     prometheus,
+		Nodes:          []NodeConfig{{Name: "hive", FQDN: "hive.example.com"}},
+	})
+
+	status := func() string {
+		w := s.get("/status")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-Status-Degraded"))
+		return w.Body.String()
+	}
+
+	// The first request waits for the first snapshot
+	first := status()
+	assert.Contains(t, first, `<td class="ok">1.00</td>`)
+	assert.Contains(t, first, "Updated 0s ago.")
+
+	// The collector refreshes in the background
+	atomic.StoreInt32(&load, 2)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(status(), `<td class="ok">2.00</td>`)
+	}, time.Second, 10*time.Millisecond)
+
+	// Requests don't trigger queries, they render the latest snapshot
+	assert.NoError(t, s.Close())
+	queried := len(queries.all())
+	assert.Contains(t, status(), `<td class="ok">2.00</td>`)
+	assert.Len(t, queries.all(), queried)
+}
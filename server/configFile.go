@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ServiceTypeProbe = "probe"
+	ServiceTypeSNMP  = "snmp"
+)
+
+// Node names end up in URLs and CSS class names
+var nodeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// NodeConfig describes a monitored server and the services running on it
+type NodeConfig struct {
+	Name string `yaml:"name"`
+	FQDN string `yaml:"fqdn"`
+	// The MetricsSource to query, prometheus if empty
+	Source   string          `yaml:"source"`
+	Services []ServiceConfig `yaml:"services"`
+}
+
+// ServiceConfig describes a single service shown on the status page.
+// Probes are checked with probe_success and probe_duration_seconds of the
+// given instance, SNMP services show the upstream utilisation of an interface
+// of the instance they were scraped from.
+type ServiceConfig struct {
+	Name      string  `yaml:"name"`
+	Type      string  `yaml:"type"`
+	Instance  string  `yaml:"instance"`
+	Interface string  `yaml:"interface"`
+	Capacity  float64 `yaml:"capacity"` // octets per second
+}
+
+// snmpQuery selects the upstream octets of the interface of an SNMP service
+func (service ServiceConfig) snmpQuery() MetricQuery {
+	labels := map[string]string{"job": "snmp", "ifName": service.Interface}
+	if service.Instance != "" {
+		labels["instance"] = service.Instance
+	}
+	return MetricQuery{Metric: "ifHCOutOctets", Labels: labels, Rate: true}
+}
+
+// LoadFile reads the YAML configuration file at path into the config.
+// Options set by flags are not touched.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return c.validate()
+}
+
+func (c *Config) validate() error {
+	if c.StatusInterval <= 0 {
+		c.StatusInterval = 30 * time.Second
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = 30 * time.Second
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("a TLS certificate requires a key and the other way around")
+	}
+	if c.TLSCert == "" && (c.TLSOCSPStaple != "" || c.TLSRedirectAddress != "") {
+		return fmt.Errorf("OCSP stapling and the HTTPS redirect require a TLS certificate")
+	}
+
+	if c.SocketMode == "" {
+		c.SocketMode = "0660"
+	}
+	if _, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil {
+		return fmt.Errorf("invalid socket mode '%s'", c.SocketMode)
+	}
+
+	if err := c.Prometheus.validate(); err != nil {
+		return fmt.Errorf("invalid prometheus configuration: %w", err)
+	}
+	if c.InfluxDB != nil {
+		if err := c.InfluxDB.validate(); err != nil {
+			return fmt.Errorf("invalid influxdb configuration: %w", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	interfaces := make(map[string]bool)
+	for i := range c.Nodes {
+		node := &c.Nodes[i]
+		if !nodeNameRegex.MatchString(node.Name) {
+			return fmt.Errorf("invalid node name '%s'", node.Name)
+		}
+		if names[node.Name] {
+			return fmt.Errorf("duplicate node name '%s'", node.Name)
+		}
+		names[node.Name] = true
+		if node.FQDN == "" {
+			return fmt.Errorf("node '%s' has no fqdn", node.Name)
+		}
+		switch node.Source {
+		case "", MetricsSourcePrometheus:
+		case MetricsSourceInfluxDB:
+			if c.InfluxDB == nil {
+				return fmt.Errorf("node '%s' uses influxdb, but it is not configured", node.Name)
+			}
+		default:
+			return fmt.Errorf("node '%s' has unknown source '%s'", node.Name, node.Source)
+		}
+
+		for j := range node.Services {
+			service := &node.Services[j]
+			if service.Name == "" {
+				return fmt.Errorf("service %d of node '%s' has no name", j, node.Name)
+			}
+			switch service.Type {
+			case ServiceTypeProbe:
+				if service.Instance == "" {
+					return fmt.Errorf("probe '%s' of node '%s' has no instance", service.Name, node.Name)
+				}
+			case ServiceTypeSNMP:
+				if service.Interface == "" {
+					service.Interface = "eth0"
+				}
+				if service.Capacity <= 0 {
+					service.Capacity = 50000 * 1000
+				}
+				// Their queries would match the series of both
+				if interfaces[service.Instance+"/"+service.Interface] {
+					return fmt.Errorf("snmp service '%s' of node '%s' selects the same interface as another one, set their instance", service.Name, node.Name)
+				}
+				interfaces[service.Instance+"/"+service.Interface] = true
+			default:
+				return fmt.Errorf("service '%s' of node '%s' has unknown type '%s'", service.Name, node.Name, service.Type)
+			}
+		}
+	}
+
+	for i := range c.Announcements {
+		if err := c.Announcements[i].validate(c.Nodes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(path, []byte(`nodes:
+  - name: hive
+    fqdn: hive.example.com
+    services:
+      - name: Plex
+        type: probe
+        instance: plex.example.com:32400
+      - name: Upstream Load
+        type: snmp
+        instance: router.hive.example.com
+  - name: helios
+    fqdn: helios.example.com
+    services:
+      - name: Upstream Load
+        type: snmp
+        instance: router.helios.example.com
+tls_cert: /etc/hashworksNET/cert.pem
+tls_key: /etc/hashworksNET/key.pem
+`), 0600)
+	assert.NoError(t, err)
+
+	config := Config{Debug: true}
+	if !assert.NoError(t, config.LoadFile(path)) {
+		t.FailNow()
+	}
+	assert.True(t, config.Debug)
+	assert.Len(t, config.Nodes, 2)
+	assert.Equal(t, "hive.example.com", config.Nodes[0].FQDN)
+	assert.Equal(t, ServiceTypeProbe, config.Nodes[0].Services[0].Type)
+	assert.Equal(t, "eth0", config.Nodes[0].Services[1].Interface)
+	assert.Equal(t, float64(50000*1000), config.Nodes[0].Services[1].Capacity)
+	assert.Equal(t, "/etc/hashworksNET/cert.pem", config.TLSCert)
+	assert.Equal(t, "/etc/hashworksNET/key.pem", config.TLSKey)
+	config.TLSCert, config.TLSKey = "", ""
+
+	// The same interface of different targets is told apart by the instance
+	assert.Equal(t, map[string]string{"job": "snmp", "ifName": "eth0", "instance": "router.helios.example.com"}, config.Nodes[1].Services[0].snmpQuery().Labels)
+	assert.Equal(t, map[string]string{"job": "snmp", "ifName": "eth1"}, ServiceConfig{Interface: "eth1"}.snmpQuery().Labels)
+
+	s := newTestServer(t, config)
+	routes := make(map[string]bool)
+	for _, route := range s.Router.Routes() {
+		routes[route.Path] = true
+	}
+	for _, node := range []string{"hive", "helios"} {
+		assert.True(t, routes["/load-"+node+".svg"])
+	}
+}
+
+func TestInvalidConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	for _, test := range []struct {
+		name, config string
+	}{
+		{"node without fqdn", "nodes:\n  - name: hive\n"},
+		{"node name with slash", "nodes:\n  - name: hive/1\n    fqdn: a\n"},
+		{"duplicate node", "nodes:\n  - name: hive\n    fqdn: a\n  - name: hive\n    fqdn: b\n"},
+		{"probe without instance", "nodes:\n  - name: hive\n    fqdn: a\n    services:\n      - name: Plex\n        type: probe\n"},
+		{"unknown service type", "nodes:\n  - name: hive\n    fqdn: a\n    services:\n      - name: Plex\n        type: unknown\n"},
+		{"snmp interface without instance", "nodes:\n  - name: hive\n    fqdn: a\n    services:\n      - name: Uplink\n        type: snmp\n  - name: helios\n    fqdn: b\n    services:\n      - name: Uplink\n        type: snmp\n"},
+		{"unknown key", "nodes:\n  - name: hive\n    fqdn: a\n    unknownKey: true\n"},
+		{"announcement without title", "announcements:\n  - type: incident\n"},
+		{"unknown announcement type", "announcements:\n  - type: outage\n    title: a\n"},
+		{"announcement of unknown service", "nodes:\n  - name: hive\n    fqdn: a\nannouncements:\n  - type: maintenance\n    title: a\n    affects: [hive/Plex]\n"},
+		{"announcement ending before start", "announcements:\n  - type: incident\n    title: a\n    start: 2022-03-20T18:00:00Z\n    end: 2022-03-20T14:00:00Z\n"},
+		{"certificate without key", "tls_cert: /etc/hashworksNET/cert.pem\n"},
+		{"staple without certificate", "tls_ocsp_staple: /etc/hashworksNET/ocsp.der\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, os.WriteFile(path, []byte(test.config), 0600))
+			assert.Error(t, (&Config{}).LoadFile(path))
+		})
+	}
+}
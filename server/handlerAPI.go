@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-errors/errors"
+)
+
+// apiVersion is increased on incompatible changes of the JSON API
+const apiVersion = 1
+
+// apiStatus is returned by /api/v1/status and /status for JSON requests
+type apiStatus struct {
+	Version int `json:"version"`
+	// When the status was collected
+	Time          time.Time      `json:"time"`
+	Degraded      bool           `json:"degraded"`
+	Announcements []Announcement `json:"announcements"`
+	Nodes         []Node         `json:"nodes"`
+}
+
+// apiNodeStatus is returned by /api/v1/status/:node
+type apiNodeStatus struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+	Degraded bool      `json:"degraded"`
+	Node     Node      `json:"node"`
+}
+
+func newAPIStatus(snapshot statusSnapshot, degraded bool, announcements []Announcement) apiStatus {
+	// Consumers shouldn't need to handle null
+	if announcements == nil {
+		announcements = []Announcement{}
+	}
+	nodes := snapshot.Nodes
+	if nodes == nil {
+		nodes = []Node{}
+	}
+
+	return apiStatus{
+		Version:       apiVersion,
+		Time:          snapshot.Time,
+		Degraded:      degraded,
+		Announcements: announcements,
+		Nodes:         nodes,
+	}
+}
+
+func (s *Server) handlerAPIStatus(c *gin.Context) {
+	snapshot, ok := s.statusSnapshot(c.Request.Context())
+	if !ok {
+		s.recoveryHandlerStatus(http.StatusServiceUnavailable, c, errors.New("No status collected yet."))
+		return
+	}
+
+	degraded := s.statusHeaders(c, snapshot.Time, snapshot.Nodes)
+	c.JSON(http.StatusOK, newAPIStatus(snapshot, degraded, s.announcements.current(time.Now())))
+}
+
+func (s *Server) handlerAPINodeStatus(c *gin.Context) {
+	snapshot, ok := s.statusSnapshot(c.Request.Context())
+	if !ok {
+		s.recoveryHandlerStatus(http.StatusServiceUnavailable, c, errors.New("No status collected yet."))
+		return
+	}
+
+	for _, node := range snapshot.Nodes {
+		if node.Name == c.Param("node") {
+			degraded := s.statusHeaders(c, snapshot.Time, []Node{node})
+			c.JSON(http.StatusOK, apiNodeStatus{
+				Version:  apiVersion,
+				Time:     snapshot.Time,
+				Degraded: degraded,
+				Node:     node,
+			})
+			return
+		}
+	}
+
+	errorJSON(c, http.StatusNotFound, errors.New("Unknown node."))
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusAPI(t *testing.T) {
+	prometheus, _ := newPrometheusTestConfig(t, func(metric string) string {
+		switch metric {
+		case "ifHCOutOctets":
+			return ""
+		case "probe_duration_seconds":
+			return "0.05"
+		}
+		return "1"
+	})
+
+	s := newTestServer(t, Config{
+		Prometheus: prometheus,
+		Nodes: []NodeConfig{{
+			Name: "hive",
+			FQDN: "hive.example.com",
+			Services: []ServiceConfig{
+				{Name: "Plex", Type: ServiceTypeProbe, Instance: "plex.example.com:32400"},
+				{Name: "Upstream Load", Type: ServiceTypeSNMP, Interface: "eth0", Capacity: 1000},
+			},
+		}, {
+			Name:     "helios",
+			FQDN:     "helios.example.com",
+			Services: []ServiceConfig{{Name: "DNS", Type: ServiceTypeProbe, Instance: "dns.example.com:853"}},
+		}},
+	})
+
+	request := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		s.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("/api/v1/status", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Status-Degraded"))
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	var status apiStatus
+	if !assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status)) {
+		t.FailNow()
+	}
+	assert.Equal(t, apiVersion, status.Version)
+	assert.True(t, status.Degraded)
+	assert.WithinDuration(t, time.Now(), status.Time, time.Minute)
+	assert.Empty(t, status.Announcements)
+	if assert.Len(t, status.Nodes, 2) {
+		assert.Equal(t, "hive", status.Nodes[0].Name)
+		assert.Equal(t, []Load{{"1m", "ok", 1}, {"5m", "ok", 1}, {"15m", "ok", 1}}, status.Nodes[0].Loads)
+		assert.Equal(t, Service{Name: "Plex", Status: "ok", Message: "Online. 0.05s latency.", Summary: "online 0.05s"}, status.Nodes[0].Services[0])
+		assert.Equal(t, "unknown", status.Nodes[0].Services[1].Status)
+	}
+	assert.Contains(t, w.Body.String(), `"announcements":[]`)
+
+	// Content negotiation on the status page returns the same payload
+	w = request("/status", "application/json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+	var negotiated apiStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &negotiated))
+	assert.Equal(t, status, negotiated)
+
+	w = request("/status", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+
+	w = request("/api/v1/status/helios", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Status-Degraded"))
+	var nodeStatus apiNodeStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &nodeStatus))
+	assert.Equal(t, apiVersion, nodeStatus.Version)
+	assert.False(t, nodeStatus.Degraded)
+	assert.Equal(t, "helios", nodeStatus.Node.Name)
+	assert.Equal(t, "ok", nodeStatus.Node.Services[0].Status)
+
+	w = request("/api/v1/status/unknown", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Unknown node.")
+}
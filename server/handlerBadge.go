@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-errors/errors"
+)
+
+const (
+	badgeHeight = 20
+	// Rough width of a character at the badge font size, there is no font
+	// metrics to measure the text with
+	badgeCharacterWidth = 7
+	badgePadding        = 6
+)
+
+// badgeSVG renders a flat badge with a label and a value colored by status.
+// The chart CSS is embedded, its hash is already allowed by the CSP.
+func (s *Server) badgeSVG(c *gin.Context, code int, label, value, status string) {
+	labelWidth := len(label)*badgeCharacterWidth + 2*badgePadding
+	valueWidth := len(value)*badgeCharacterWidth + 2*badgePadding
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	c.Header("Content-Type", "image/svg+xml")
+	c.String(code, fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s: %s">`+
+		`<style type="text/css"><![CDATA[%s]]></style>`+
+		`<title>%s: %s</title>`+
+		`<rect class="badge label" width="%d" height="%d"/>`+
+		`<rect class="badge %s" x="%d" width="%d" height="%d"/>`+
+		`<text class="badge text" x="%d" y="14">%s</text>`+
+		`<text class="badge text" x="%d" y="14">%s</text>`+
+		`</svg>`,
+		labelWidth+valueWidth, badgeHeight, label, value,
+		s.chartCSS,
+		label, value,
+		labelWidth, badgeHeight,
+		status, labelWidth, valueWidth, badgeHeight,
+		badgePadding, label,
+		labelWidth+badgePadding, value))
+}
+
+func (s *Server) handlerBadge(c *gin.Context) {
+	serviceName := strings.TrimSuffix(c.Param("service"), ".svg")
+	if serviceName == c.Param("service") {
+		s.badgeSVG(c, http.StatusNotFound, "badge", "not found", "unknown")
+		return
+	}
+
+	snapshot, ok := s.statusSnapshot(c.Request.Context())
+	if !ok {
+		s.recoveryHandlerStatus(http.StatusServiceUnavailable, c, errors.New("No status collected yet."))
+		return
+	}
+
+	for _, node := range snapshot.Nodes {
+		if node.Name != c.Param("node") {
+			continue
+		}
+		for _, service := range node.Services {
+			if service.Name == serviceName {
+				c.Header("Cache-Control", fmt.Sprintf("max-age=%d", int(s.config.StatusInterval.Seconds())))
+				c.Header("Last-Modified", snapshot.Time.Format(time.RFC1123))
+				s.badgeSVG(c, http.StatusOK, strings.ToLower(service.Name), service.Summary, service.Status)
+				return
+			}
+		}
+	}
+
+	s.badgeSVG(c, http.StatusNotFound, "badge", "not found", "unknown")
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBadge(t *testing.T) {
+	prometheus, _ := newPrometheusTestConfig(t, func(metric string) string {
+		switch metric {
+		case "ifHCOutOctets":
+			return "600"
+		case "probe_duration_seconds":
+			return "0.05"
+		}
+		return "1"
+	})
+
+	s := newTestServer(t, Config{
+		Prometheus: prometheus,
+		Nodes: []NodeConfig{{
+			Name: "hive",
+			FQDN: "hive.example.com",
+			Services: []ServiceConfig{
+				{Name: "Plex", Type: ServiceTypeProbe, Instance: "plex.example.com:32400"},
+				{Name: "Upstream Load", Type: ServiceTypeSNMP, Interface: "eth0", Capacity: 1000},
+			},
+		}},
+	})
+
+	w := s.get("/badge/hive/Plex.svg")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "max-age=30", w.Header().Get("Cache-Control"))
+	body := w.Body.String()
+	assert.Contains(t, body, `<title>plex: online 0.05s</title>`)
+	assert.Contains(t, body, `class="badge ok"`)
+	// The embedded style must be allowed by the CSP
+	assert.Contains(t, body, "<![CDATA["+s.chartCSS+"]]>")
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "'sha256-"+s.cssSha256[1]+"'")
+
+	w = s.get("/badge/hive/Upstream%20Load.svg")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<title>upstream load: 60% utilisation</title>`)
+	assert.Contains(t, w.Body.String(), `class="badge warning"`)
+
+	for _, path := range []string{"/badge/hive/DNS.svg", "/badge/helios/Plex.svg", "/badge/hive/Plex"} {
+		t.Run(path, func(t *testing.T) {
+			w := s.get(path)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.Contains(t, w.Body.String(), "not found")
+		})
+	}
+}
@@ -0,0 +1,915 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	drawingUpstream "github.com/wcharczuk/go-chart/drawing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-errors/errors"
+	"github.com/hashworks/go-chart"
+)
+
+// chartFileRegex matches the last part of /chart/:node/:metric.svg, charts
+// are rendered as PNG with the .png suffix instead. The .json suffix returns
+// a summary of the chart.
+var chartFileRegex = regexp.MustCompile(`^([a-z-]+)\.(svg|png|json)$`)
+
+// chartQueryParams are the query parameters charts accept, the others are
+// dropped before the cache lookup
+var chartQueryParams = []string{"range", "mountpoint", "device", "direction", "service"}
+
+// Charts are drawn in this size and scaled with their viewBox. chart.scss
+// enlarges the labels when they are shown small.
+const (
+	chartWidth  = 1000
+	chartHeight = 250
+	// Few enough time labels to stay readable when enlarged
+	chartTimeTicks = 6
+	// Charts grow by a line per series for the caption
+	chartCaptionLineHeight = 20
+)
+
+// Colors of PNG charts, which can't be styled with chart.scss. Keep them in
+// sync with sass/_configuration.scss.
+var (
+	chartBackgroundColor = drawingUpstream.ColorFromHex("272727")
+	chartLineColor       = drawingUpstream.ColorFromHex("444448")
+	chartTextColor       = drawingUpstream.ColorWhite
+	chartStatusColors    = map[string]drawingUpstream.Color{
+		"ok":      drawingUpstream.ColorFromHex("065535"),
+		"warning": drawingUpstream.ColorFromHex("996000"),
+		"error":   drawingUpstream.ColorFromHex("800000"),
+	}
+	chartSeriesColors = []drawingUpstream.Color{
+		drawingUpstream.ColorFromHex("3a7ca5"),
+		drawingUpstream.ColorFromHex("d9a441"),
+		drawingUpstream.ColorFromHex("8e5ea2"),
+		drawingUpstream.ColorFromHex("4f9d69"),
+		drawingUpstream.ColorFromHex("c8553d"),
+		drawingUpstream.ColorFromHex("9a9a9a"),
+	}
+	chartMarkerColors = map[string]drawingUpstream.Color{
+		"deploy": drawingUpstream.ColorFromHex("999999"),
+		"reboot": drawingUpstream.ColorWhite,
+	}
+)
+
+// Opacity of areas, like transparentize() in chart.scss
+const (
+	chartFillAlpha        = 102
+	chartStackedFillAlpha = 204
+	chartOutageFillAlpha  = 51
+)
+
+// maxChartPoints bounds the number of samples queried for a chart
+const maxChartPoints = 360
+
+// chartRange is a time range selectable with the range query parameter
+type chartRange struct {
+	Name     string
+	Duration time.Duration
+	// Used in messages like "in the last hour"
+	Label string
+	// Format of the X axis labels
+	TimeFormat string
+}
+
+// chartRanges are the selectable ranges, the first one is the default
+var chartRanges = []chartRange{
+	{"1h", time.Hour, "hour", "15:04"},
+	{"6h", 6 * time.Hour, "6 hours", "15:04"},
+	{"24h", 24 * time.Hour, "24 hours", "15:04"},
+	{"7d", 7 * 24 * time.Hour, "7 days", "Mon 2"},
+	{"30d", 30 * 24 * time.Hour, "30 days", "Jan 2"},
+}
+
+func chartRangeByName(name string) (chartRange, bool) {
+	if name == "" {
+		return chartRanges[0], true
+	}
+	for _, r := range chartRanges {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return chartRange{}, false
+}
+
+// step returns the resolution of the range, keeping the number of samples
+// below maxChartPoints
+func (r chartRange) step() time.Duration {
+	step := (r.Duration / maxChartPoints).Truncate(time.Minute)
+	if step < time.Minute {
+		return time.Minute
+	}
+	return step
+}
+
+// chartSeries is one line of a chart
+type chartSeries struct {
+	// The title of the metric if empty
+	Name    string
+	Queries []MetricQuery
+	// Value returns the charted value from the values of all queries at a
+	// point in time, the value of the first query if nil
+	Value func(values []float64) float64
+	// Capacity returns the maximum of the value, nil if it is unbounded
+	Capacity func(values []float64) float64
+	// Node is set by chartMetric.nodeSeries
+	Node NodeConfig
+}
+
+// chartMetric describes a chart of a node
+type chartMetric struct {
+	Title string
+	// Series returns the series of the chart, params are the URL query
+	// parameters like the mountpoint of disk charts
+	Series    func(nodeConfig NodeConfig, params url.Values) ([]chartSeries, error)
+	Formatter chart.ValueFormatter
+	// Thresholds of the warning and error status, disabled if 0. They are
+	// fractions of the capacity if there is one.
+	Warning float64
+	Error   float64
+	// Stacked draws the series as areas on top of each other
+	Stacked bool
+}
+
+var chartMetrics = map[string]chartMetric{
+	"load": {
+		Title: "Load",
+		Series: func(nodeConfig NodeConfig, _ url.Values) ([]chartSeries, error) {
+			return []chartSeries{{Queries: []MetricQuery{{Metric: "node_load1", Labels: map[string]string{"fqdn": nodeConfig.FQDN}}}}}, nil
+		},
+		Formatter: chart.FloatValueFormatter,
+		Warning:   loadWarningThreshold,
+		Error:     loadErrorThreshold,
+	},
+	"loadavg": {
+		Title: "Load average",
+		Series: func(nodeConfig NodeConfig, _ url.Values) ([]chartSeries, error) {
+			labels := map[string]string{"fqdn": nodeConfig.FQDN}
+			return []chartSeries{
+				{Name: "1m", Queries: []MetricQuery{{Metric: "node_load1", Labels: labels}}},
+				{Name: "5m", Queries: []MetricQuery{{Metric: "node_load5", Labels: labels}}},
+				{Name: "15m", Queries: []MetricQuery{{Metric: "node_load15", Labels: labels}}},
+			}, nil
+		},
+		Formatter: chart.FloatValueFormatter,
+		Warning:   loadWarningThreshold,
+		Error:     loadErrorThreshold,
+	},
+	"cpu": {
+		Title: "CPU",
+		Series: func(nodeConfig NodeConfig, _ url.Values) ([]chartSeries, error) {
+			return []chartSeries{{
+				Queries: []MetricQuery{{
+					Metric:  "node_cpu_seconds_total",
+					Labels:  map[string]string{"fqdn": nodeConfig.FQDN, "mode": "idle"},
+					Rate:    true,
+					Average: true,
+				}},
+				Value:    func(values []float64) float64 { return (1 - values[0]) * 100 },
+				Capacity: func(_ []float64) float64 { return 100 },
+			}}, nil
+		},
+		Formatter: formatPercent,
+		Warning:   0.7,
+		Error:     0.9,
+	},
+	"memory": {
+		Title: "Memory",
+		Series: func(nodeConfig NodeConfig, _ url.Values) ([]chartSeries, error) {
+			labels := map[string]string{"fqdn": nodeConfig.FQDN}
+			return []chartSeries{{
+				Queries: []MetricQuery{
+					{Metric: "node_memory_MemTotal_bytes", Labels: labels},
+					{Metric: "node_memory_MemAvailable_bytes", Labels: labels},
+				},
+				Value:    func(values []float64) float64 { return values[0] - values[1] },
+				Capacity: func(values []float64) float64 { return values[0] },
+			}}, nil
+		},
+		Formatter: formatBytes,
+		Warning:   0.8,
+		Error:     0.95,
+	},
+	"memory-breakdown": {
+		Title: "Memory",
+		Series: func(nodeConfig NodeConfig, _ url.Values) ([]chartSeries, error) {
+			labels := map[string]string{"fqdn": nodeConfig.FQDN}
+			return []chartSeries{
+				{
+					Name: "Used",
+					Queries: []MetricQuery{
+						{Metric: "node_memory_MemTotal_bytes", Labels: labels},
+						{Metric: "node_memory_MemFree_bytes", Labels: labels},
+						{Metric: "node_memory_Buffers_bytes", Labels: labels},
+						{Metric: "node_memory_Cached_bytes", Labels: labels},
+					},
+					Value:    func(values []float64) float64 { return values[0] - values[1] - values[2] - values[3] },
+					Capacity: func(values []float64) float64 { return values[0] },
+				},
+				{Name: "Buffers", Queries: []MetricQuery{{Metric: "node_memory_Buffers_bytes", Labels: labels}}},
+				{Name: "Cached", Queries: []MetricQuery{{Metric: "node_memory_Cached_bytes", Labels: labels}}},
+				{Name: "Free", Queries: []MetricQuery{{Metric: "node_memory_MemFree_bytes", Labels: labels}}},
+			}, nil
+		},
+		Formatter: formatBytes,
+		Stacked:   true,
+	},
+	"disk": {
+		Title: "Disk",
+		Series: func(nodeConfig NodeConfig, params url.Values) ([]chartSeries, error) {
+			labels := map[string]string{"fqdn": nodeConfig.FQDN, "mountpoint": "/"}
+			if mountpoint := params.Get("mountpoint"); mountpoint != "" {
+				labels["mountpoint"] = mountpoint
+			}
+			return []chartSeries{{
+				Queries: []MetricQuery{
+					{Metric: "node_filesystem_size_bytes", Labels: labels},
+					{Metric: "node_filesystem_avail_bytes", Labels: labels},
+				},
+				Value:    func(values []float64) float64 { return values[0] - values[1] },
+				Capacity: func(values []float64) float64 { return values[0] },
+			}}, nil
+		},
+		Formatter: formatBytes,
+		Warning:   0.8,
+		Error:     0.95,
+	},
+	"network": {
+		Title: "Network",
+		Series: func(nodeConfig NodeConfig, params url.Values) ([]chartSeries, error) {
+			labels := map[string]string{"fqdn": nodeConfig.FQDN, "device": "eth0"}
+			if device := params.Get("device"); device != "" {
+				labels["device"] = device
+			}
+			metric := "node_network_transmit_bytes_total"
+			switch params.Get("direction") {
+			case "", "transmit":
+			case "receive":
+				metric = "node_network_receive_bytes_total"
+			default:
+				return nil, errors.New("Direction must be transmit or receive.")
+			}
+			return []chartSeries{{
+				Queries: []MetricQuery{{Metric: metric, Labels: labels, Rate: true}},
+				Value:   func(values []float64) float64 { return values[0] * 8 },
+			}}, nil
+		},
+		Formatter: formatBitsPerSecond,
+	},
+	"latency": {
+		Title: "Latency",
+		Series: func(nodeConfig NodeConfig, params url.Values) ([]chartSeries, error) {
+			for _, service := range nodeConfig.Services {
+				if service.Type == ServiceTypeProbe && service.Name == params.Get("service") {
+					return []chartSeries{{
+						Queries: []MetricQuery{{Metric: "probe_duration_seconds", Labels: map[string]string{"instance": service.Instance}}},
+						Value:   func(values []float64) float64 { return values[0] * 1000 },
+					}}, nil
+				}
+			}
+			return nil, errors.New("Unknown probe service.")
+		},
+		Formatter: formatMilliseconds,
+		Warning:   probeLatencyWarningThreshold * 1000,
+	},
+	"utilisation": {
+		Title: "Utilisation",
+		Series: func(nodeConfig NodeConfig, params url.Values) ([]chartSeries, error) {
+			for _, service := range nodeConfig.Services {
+				if service.Type == ServiceTypeSNMP && service.Name == params.Get("service") {
+					capacity := service.Capacity
+					return []chartSeries{{
+						Queries:  []MetricQuery{service.snmpQuery()},
+						Value:    func(values []float64) float64 { return math.Min(values[0]/capacity*100, 100) },
+						Capacity: func(_ []float64) float64 { return 100 },
+					}}, nil
+				}
+			}
+			return nil, errors.New("Unknown SNMP service.")
+		},
+		Formatter: formatPercent,
+		Warning:   0.5,
+		Error:     0.9,
+	},
+}
+
+// nodeSeries returns the series of the chart of a node
+func (m chartMetric) nodeSeries(nodeConfig NodeConfig, params url.Values) ([]chartSeries, error) {
+	series, err := m.Series(nodeConfig, params)
+	if err != nil {
+		return nil, err
+	}
+	for i := range series {
+		series[i].Node = nodeConfig
+		if series[i].Name == "" {
+			series[i].Name = m.Title
+		}
+	}
+	return series, nil
+}
+
+func (s chartSeries) value(values []float64) float64 {
+	if s.Value == nil {
+		return values[0]
+	}
+	return s.Value(values)
+}
+
+// status classifies a value like the status page does
+func (m chartMetric) status(value, capacity float64) string {
+	warning, err := m.Warning, m.Error
+	if capacity > 0 {
+		warning *= capacity
+		err *= capacity
+	}
+
+	if m.Error > 0 && value >= err {
+		return "error"
+	} else if m.Warning > 0 && value >= warning {
+		return "warning"
+	}
+	return "ok"
+}
+
+func chartFloat(v interface{}) float64 {
+	switch value := v.(type) {
+	case float64:
+		return value
+	case int:
+		return float64(value)
+	}
+	return 0
+}
+
+func formatPercent(v interface{}) string {
+	return fmt.Sprintf("%.0f%%", chartFloat(v))
+}
+
+func formatMilliseconds(v interface{}) string {
+	return fmt.Sprintf("%.0f ms", chartFloat(v))
+}
+
+func formatBytes(v interface{}) string {
+	return formatUnit(chartFloat(v), 1024, []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"})
+}
+
+func formatBitsPerSecond(v interface{}) string {
+	return formatUnit(chartFloat(v), 1000, []string{"bit/s", "kbit/s", "Mbit/s", "Gbit/s", "Tbit/s"})
+}
+
+// formatUnit formats a value with the largest unit it is at least one of
+func formatUnit(value, base float64, units []string) string {
+	unit := 0
+	for math.Abs(value) >= base && unit < len(units)-1 {
+		value /= base
+		unit++
+	}
+	precision := 0
+	if math.Abs(value) < 10 && value != math.Trunc(value) {
+		precision = 1
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64) + " " + units[unit]
+}
+
+// drawChart renders a chart as SVG styled by chart.scss or as PNG with the
+// colors of its styles. go-chart embeds its font, PNGs don't depend on the
+// fonts of the host.
+func (s *Server) drawChart(c *gin.Context, graph chart.Chart, format string) {
+	renderer := chart.SVGWithCSS(s.chartCSS, "")
+	if format == "png" {
+		renderer = chart.PNG
+	}
+
+	var image bytes.Buffer
+	if err := graph.Render(renderer, &image); err != nil {
+		s.requestLogger(c).Error(err.Error())
+		c.AbortWithStatus(500)
+		return
+	}
+
+	c.Header("Cache-Control", "max-age=600")
+	c.Header("Last-Modified", time.Now().Format(time.RFC1123))
+	if format == "png" {
+		c.Data(200, chart.ContentTypePNG, image.Bytes())
+		return
+	}
+	c.Data(200, chart.ContentTypeSVG, responsiveSVG(image.Bytes(), graph.Width, graph.Height))
+}
+
+// responsiveSVG replaces the fixed size of an SVG rendered by go-chart with a
+// viewBox, so it scales to the size it is shown in
+func responsiveSVG(svg []byte, width, height int) []byte {
+	return bytes.Replace(svg,
+		[]byte(fmt.Sprintf(`width="%d" height="%d"`, width, height)),
+		[]byte(fmt.Sprintf(`viewBox="0 0 %d %d" preserveAspectRatio="xMidYMid meet"`, width, height)), 1)
+}
+
+// timeTicks returns evenly spaced ticks between the first and the last sample
+func timeTicks(samples []Sample, format string) []chart.Tick {
+	start, end := samples[0].Time, samples[len(samples)-1].Time
+	ticks := make([]chart.Tick, 0, chartTimeTicks)
+	for i := 0; i < chartTimeTicks; i++ {
+		t := start.Add(end.Sub(start) * time.Duration(i) / (chartTimeTicks - 1))
+		ticks = append(ticks, chart.Tick{Value: float64(t.UnixNano()), Label: t.Format(format)})
+	}
+	return ticks
+}
+
+// seriesSamples queries a series of a chart and combines the values of its
+// queries at every point in time. It also returns the latest capacity, 0 if
+// the series has none.
+func (s *Server) seriesSamples(ctx context.Context, series chartSeries, start, end time.Time, step time.Duration) ([]Sample, float64, error) {
+	source := s.metricsSource(series.Node)
+
+	results := make([][]Sample, len(series.Queries))
+	for i, query := range series.Queries {
+		samples, err := source.Range(ctx, query, start, end, step)
+		if err != nil {
+			return nil, 0, fmt.Errorf("query '%s' for %s failed: %w", query.Metric, series.Node.Name, err)
+		}
+		results[i] = samples
+	}
+
+	// Only points in time with a value of every query can be charted
+	valuesAt := make(map[int64][]float64)
+	for i, samples := range results {
+		for _, sample := range samples {
+			if values := valuesAt[sample.Time.Unix()]; len(values) == i {
+				valuesAt[sample.Time.Unix()] = append(values, sample.Value)
+			}
+		}
+	}
+
+	var samples []Sample
+	var capacity float64
+	for _, sample := range results[0] {
+		values := valuesAt[sample.Time.Unix()]
+		if len(values) != len(series.Queries) {
+			continue
+		}
+		samples = append(samples, Sample{sample.Time, series.value(values)})
+		if series.Capacity != nil {
+			capacity = series.Capacity(values)
+		}
+	}
+
+	return samples, capacity, nil
+}
+
+// stackSamples adds the values of the previous series to every series, at the
+// points in time all series have a value at
+func stackSamples(samples [][]Sample) [][]Sample {
+	count := make(map[int64]int)
+	for _, series := range samples {
+		for _, sample := range series {
+			count[sample.Time.Unix()]++
+		}
+	}
+
+	stacked := make([][]Sample, len(samples))
+	sums := make(map[int64]float64)
+	for i, series := range samples {
+		for _, sample := range series {
+			if count[sample.Time.Unix()] != len(samples) {
+				continue
+			}
+			sums[sample.Time.Unix()] += sample.Value
+			stacked[i] = append(stacked[i], Sample{sample.Time, sums[sample.Time.Unix()]})
+		}
+	}
+	return stacked
+}
+
+// chartLegend is chart.Legend with the line of every series drawn with the
+// class of the series, so the colors match when styled with CSS
+func chartLegend(graph *chart.Chart) chart.Renderable {
+	return func(r chart.Renderer, box chart.Box, defaults chart.Style) {
+		style := chart.Style{
+			ClassName:   "legend",
+			FillColor:   chartBackgroundColor,
+			FontColor:   chartTextColor,
+			FontSize:    8.0,
+			StrokeColor: chartLineColor,
+			StrokeWidth: chart.DefaultAxisLineWidth,
+		}.InheritFrom(defaults)
+		const padding, lineGap, lineLength = 5, 5, 25
+
+		var series []chart.Series
+		for _, s := range graph.Series {
+			if s.GetStyle().Show && s.GetName() != "" {
+				series = append(series, s)
+			}
+		}
+
+		style.GetTextOptions().WriteToRenderer(r)
+		legend := chart.Box{Top: box.Top, Left: box.Left, Right: box.Left + 2*padding, Bottom: box.Top + 2*padding}
+		for i, s := range series {
+			text := r.MeasureText(s.GetName())
+			if i > 0 {
+				legend.Bottom += chart.DefaultMinimumTickVerticalSpacing
+			}
+			legend.Bottom += text.Height()
+			if right := box.Left + 2*padding + text.Width() + lineGap + lineLength; right > legend.Right {
+				legend.Right = right
+			}
+		}
+		chart.Draw.Box(r, legend, style)
+
+		y := legend.Top + padding
+		for i, s := range series {
+			if i > 0 {
+				y += chart.DefaultMinimumTickVerticalSpacing
+			}
+			style.GetTextOptions().WriteToRenderer(r)
+			text := r.MeasureText(s.GetName())
+			r.Text(s.GetName(), legend.Left+padding, y+text.Height())
+
+			lineY := y + text.Height()/2
+			s.GetStyle().GetStrokeOptions().WriteDrawingOptionsToRenderer(r)
+			r.MoveTo(legend.Left+padding+text.Width()+lineGap, lineY)
+			r.LineTo(legend.Right-padding, lineY)
+			r.Stroke()
+
+			y += text.Height()
+		}
+	}
+}
+
+// chartSamples queries all series of a chart in a time range up to now. Series
+// without data have no samples.
+func (s *Server) chartSamples(ctx context.Context, series []chartSeries, timeRange chartRange) ([][]Sample, []float64, error) {
+	end := time.Now()
+	samples := make([][]Sample, len(series))
+	capacities := make([]float64, len(series))
+	errs := make([]error, len(series))
+	var wg sync.WaitGroup
+	for i := range series {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			samples[i], capacities[i], errs[i] = s.seriesSamples(ctx, series[i], end.Add(-timeRange.Duration), end, timeRange.step())
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, ErrNoData) {
+			return nil, nil, err
+		}
+	}
+	return samples, capacities, nil
+}
+
+// renderChart draws the series of a metric in the range selected by the
+// range query parameter, with a caption summarizing every series. Series
+// without enough data are left out. The JSON format returns the summaries.
+func (s *Server) renderChart(c *gin.Context, metric chartMetric, series []chartSeries, params url.Values, format string) {
+	timeRange, ok := chartRangeByName(params.Get("range"))
+	if !ok {
+		errorJSON(c, http.StatusBadRequest, errors.New("Unknown range."))
+		return
+	}
+
+	samples, capacities, err := s.chartSamples(c.Request.Context(), series, timeRange)
+	if err != nil {
+		if format == "json" {
+			errorJSON(c, http.StatusServiceUnavailable, errors.New(s.unavailableReason(s.requestLogger(c), err)))
+		} else {
+			s.messageChart(c, http.StatusServiceUnavailable, s.unavailableReason(s.requestLogger(c), err), format)
+		}
+		return
+	}
+
+	// Summaries are of the values before they are stacked
+	var summaries []chartSummary
+	var captions []string
+	for i, seriesSamples := range samples {
+		if len(seriesSamples) < 2 {
+			continue
+		}
+		summary := summarize(series[i].Name, seriesSamples)
+		summaries = append(summaries, summary)
+		captions = append(captions, summary.caption(metric.Formatter))
+	}
+	if format == "json" {
+		s.chartSummaryJSON(c, timeRange, summaries)
+		return
+	}
+
+	if metric.Stacked {
+		samples = stackSamples(samples)
+	}
+
+	var timeSeries []chart.Series
+	var ticks []chart.Tick
+	var first, last time.Time
+	var max, capacity float64
+	for i, seriesSamples := range samples {
+		if len(seriesSamples) < 2 {
+			continue
+		}
+
+		color := chartSeriesColors[i%len(chartSeriesColors)]
+		ts := chart.TimeSeries{
+			Name: series[i].Name,
+			Style: chart.Style{
+				Show:        true,
+				ClassName:   fmt.Sprintf("series series-%d", i),
+				StrokeColor: color,
+				StrokeWidth: 2,
+			},
+		}
+		if metric.Stacked {
+			ts.Style.ClassName += " stacked"
+			ts.Style.FillColor = color.WithAlpha(chartStackedFillAlpha)
+		}
+
+		var sum float64
+		for _, sample := range seriesSamples {
+			ts.XValues = append(ts.XValues, sample.Time)
+			ts.YValues = append(ts.YValues, sample.Value)
+			if sample.Value > max {
+				max = sample.Value
+			}
+			sum += sample.Value
+		}
+
+		// A single series is colored by its status, overlaid areas would
+		// hide each other
+		if len(series) == 1 {
+			status := metric.status(sum/float64(len(seriesSamples)), capacities[i])
+			ts.Style.ClassName = "series " + status
+			ts.Style.StrokeColor = chartStatusColors[status]
+			ts.Style.StrokeWidth = 1
+			ts.Style.FillColor = chartStatusColors[status].WithAlpha(chartFillAlpha)
+		}
+
+		if capacities[i] > capacity {
+			capacity = capacities[i]
+		}
+		if ticks == nil {
+			ticks = timeTicks(seriesSamples, timeRange.TimeFormat)
+			first, last = seriesSamples[0].Time, seriesSamples[len(seriesSamples)-1].Time
+		}
+		timeSeries = append(timeSeries, ts)
+	}
+
+	if len(timeSeries) == 0 {
+		s.messageChart(c, http.StatusOK, fmt.Sprintf("Not enough data collected in the last %s to draw a graph.", timeRange.Label), format)
+		return
+	}
+
+	if metric.Stacked {
+		// The highest area is drawn first, so the lower ones stay visible
+		for i, j := 0, len(timeSeries)-1; i < j; i, j = i+1, j-1 {
+			timeSeries[i], timeSeries[j] = timeSeries[j], timeSeries[i]
+		}
+	}
+
+	if capacity > 0 {
+		max = capacity
+	} else if max == 0 {
+		// go-chart can't draw an empty range
+		max = 1
+	}
+
+	// Annotations are only drawn into charts of a single node, thresholds of
+	// other nodes may differ
+	singleNode := true
+	for _, seriesConfig := range series {
+		singleNode = singleNode && seriesConfig.Node.Name == series[0].Node.Name
+	}
+	if singleNode {
+		background, foreground := s.chartAnnotations(c.Request.Context(), metric, series[0].Node, capacity, max, first, last, timeRange.step())
+		timeSeries = append(append(background, timeSeries...), foreground...)
+	}
+
+	graph := chart.Chart{
+		Height: chartHeight + len(captions)*chartCaptionLineHeight,
+		Width:  chartWidth,
+		Background: chart.Style{
+			ClassName: "bg",
+			FillColor: chartBackgroundColor,
+			Padding: chart.Box{
+				Top:    chart.DefaultBackgroundPadding.Top,
+				Left:   chart.DefaultBackgroundPadding.Left,
+				Right:  chart.DefaultBackgroundPadding.Right,
+				Bottom: chart.DefaultBackgroundPadding.Bottom + len(captions)*chartCaptionLineHeight,
+			},
+		},
+		Canvas: chart.Style{
+			ClassName: "bg",
+			FillColor: chartBackgroundColor,
+		},
+		XAxis: chart.XAxis{
+			Style: chart.Style{
+				ClassName:   "axis",
+				Show:        true,
+				StrokeColor: chartLineColor,
+				FontColor:   chartTextColor,
+			},
+			Ticks:          ticks,
+			ValueFormatter: chart.TimeValueFormatterWithFormat(timeRange.TimeFormat),
+		},
+		YAxis: chart.YAxis{
+			Range: &chart.ContinuousRange{Min: 0, Max: max},
+			Style: chart.Style{
+				ClassName:   "axis",
+				Show:        true,
+				StrokeColor: chartLineColor,
+				FontColor:   chartTextColor,
+			},
+			ValueFormatter: metric.Formatter,
+		},
+		Series: timeSeries,
+	}
+
+	graph.Elements = []chart.Renderable{
+		chartLegend(&graph),
+		chartCaption(&graph, captions),
+	}
+
+	s.drawChart(c, graph, format)
+}
+
+func (s *Server) handlerLoadSVG(nodeConfig NodeConfig) func(*gin.Context) {
+	return func(c *gin.Context) {
+		series, _ := chartMetrics["load"].nodeSeries(nodeConfig, nil)
+		s.renderChart(c, chartMetrics["load"], series, c.Request.URL.Query(), "svg")
+	}
+}
+
+// chartMetricByFile returns the metric and the format of a chart file like
+// load.svg
+func chartMetricByFile(c *gin.Context) (chartMetric, string, bool) {
+	match := chartFileRegex.FindStringSubmatch(c.Param("chart"))
+	if match == nil {
+		errorJSON(c, http.StatusNotFound, errors.New("Unknown chart."))
+		return chartMetric{}, "", false
+	}
+	metric, ok := chartMetrics[match[1]]
+	if !ok {
+		errorJSON(c, http.StatusNotFound, errors.New("Unknown metric."))
+		return chartMetric{}, "", false
+	}
+	return metric, match[2], true
+}
+
+// handlerChart serves /chart/:node/:metric.svg, .png and the summary .json
+func (s *Server) handlerChart(c *gin.Context) {
+	metric, format, ok := chartMetricByFile(c)
+	if !ok {
+		return
+	}
+
+	for _, nodeConfig := range s.config.Nodes {
+		if nodeConfig.Name == c.Param("node") {
+			series, err := metric.nodeSeries(nodeConfig, c.Request.URL.Query())
+			if err != nil {
+				errorJSON(c, http.StatusBadRequest, err)
+				return
+			}
+			s.renderChart(c, metric, series, c.Request.URL.Query(), format)
+			return
+		}
+	}
+
+	errorJSON(c, http.StatusNotFound, errors.New("Unknown node."))
+}
+
+// handlerCompare serves /compare/:metric.svg and its other formats, a chart of a metric with one
+// series per node. Nodes the metric isn't available for are left out.
+func (s *Server) handlerCompare(c *gin.Context) {
+	metric, format, ok := chartMetricByFile(c)
+	if !ok {
+		return
+	}
+
+	var series []chartSeries
+	var err error = errors.New("No nodes configured.")
+	for _, nodeConfig := range s.config.Nodes {
+		var nodeSeries []chartSeries
+		nodeSeries, err = metric.nodeSeries(nodeConfig, c.Request.URL.Query())
+		if err != nil {
+			continue
+		}
+		if len(nodeSeries) != 1 {
+			errorJSON(c, http.StatusBadRequest, errors.New("Only metrics with a single series can be compared."))
+			return
+		}
+		nodeSeries[0].Name = nodeConfig.Name
+		series = append(series, nodeSeries[0])
+	}
+	if len(series) == 0 {
+		errorJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	s.renderChart(c, metric, series, c.Request.URL.Query(), format)
+}
+
+// messageLines wraps a message to the width of a chart
+func messageLines(message string) []string {
+	var lines []string
+	charactersPerLine := chartWidth / 15
+	if len(message) <= charactersPerLine {
+		return []string{strings.TrimSpace(message)}
+	}
+	words := strings.Fields(strings.TrimSpace(message))
+	charactersLeft := charactersPerLine
+	line := ""
+	for _, word := range words {
+		wordLength := len(word)
+		if charactersLeft > 0 && (wordLength <= charactersLeft || wordLength > charactersPerLine) {
+			line += word + " "
+			charactersLeft -= wordLength
+		} else {
+			lines = append(lines, strings.TrimSpace(line))
+			line = word + " "
+			charactersLeft = charactersPerLine - wordLength
+		}
+	}
+	line = strings.TrimSpace(line)
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// messageChart shows a message instead of a chart. Errors are answered with
+// a non-2xx code, so they aren't cached.
+func (s *Server) messageChart(c *gin.Context, code int, message, format string) {
+	if format == "png" {
+		s.messagePNG(c, code, message)
+		return
+	}
+	messageSVG(c, code, message)
+}
+
+func messageSVG(c *gin.Context, code int, message string) {
+	var messages []string
+	for _, line := range messageLines(message) {
+		messages = append(messages, `<tspan x="0" dy="30">`+html.EscapeString(line)+`</tspan>`)
+	}
+	height := len(messages)*30 + 20
+
+	c.Header("Content-Type", "image/svg+xml")
+	c.Header("Cache-Control", "no-store")
+	c.String(code, fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 %d %d">`+
+		`<rect width="100%%" height="100%%" fill="#272727"/>`+
+		`<text x="0" y="0" fill="white" font-size="24" font-family="sans-serif">%s</text>`+
+		`</svg>`, chartWidth, height, strings.Join(messages, "")))
+}
+
+func (s *Server) messagePNG(c *gin.Context, code int, message string) {
+	lines := messageLines(message)
+	height := len(lines)*30 + 20
+
+	font, err := chart.GetDefaultFont()
+	if err != nil {
+		s.requestLogger(c).Error(err.Error())
+		c.AbortWithStatus(500)
+		return
+	}
+	r, err := chart.PNG(chartWidth, height)
+	if err != nil {
+		s.requestLogger(c).Error(err.Error())
+		c.AbortWithStatus(500)
+		return
+	}
+
+	chart.Draw.Box(r, chart.Box{Right: chartWidth, Bottom: height}, chart.Style{FillColor: chartBackgroundColor})
+	// At 72 DPI a point is a pixel, like the font-size of the SVG
+	r.SetDPI(72)
+	r.SetFont(font)
+	r.SetFontSize(24)
+	r.SetFontColor(chartTextColor)
+	for i, line := range lines {
+		r.Text(line, 0, (i+1)*30)
+	}
+
+	var image bytes.Buffer
+	if err := r.Save(&image); err != nil {
+		s.requestLogger(c).Error(err.Error())
+		c.AbortWithStatus(500)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(code, chart.ContentTypePNG, image.Bytes())
+}
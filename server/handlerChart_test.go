@@ -0,0 +1,355 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/go-chart"
+	"github.com/stretchr/testify/assert"
+)
+
+// newChartTestServer returns a server of the nodes hive and helios, whose
+// metrics are constant. hive rebooted at the returned time.
+func newChartTestServer(t *testing.T) (Server, *queryRecorder, time.Time) {
+	bootTime := time.Now().Add(-20 * time.Minute)
+	prometheus, queries := newPrometheusTestConfig(t, func(metric string) string {
+		switch metric {
+		case "node_load1":
+			return "1"
+		case "node_load5":
+			return "2"
+		case "node_load15":
+			return "3"
+		case "node_cpu_seconds_total":
+			return "0.25"
+		case "node_memory_MemTotal_bytes":
+			return "8589934592"
+		case "node_memory_MemAvailable_bytes":
+			return "4294967296"
+		case "node_memory_MemFree_bytes", "node_memory_Buffers_bytes", "node_memory_Cached_bytes":
+			return "1073741824"
+		case "node_filesystem_size_bytes":
+			return "100"
+		case "node_filesystem_avail_bytes":
+			return "2"
+		case "node_network_transmit_bytes_total":
+			return "1000"
+		case "probe_duration_seconds":
+			return "0.5"
+		case "probe_success":
+			return "0"
+		case "ifHCOutOctets":
+			return "2500000"
+		case "node_boot_time_seconds":
+			return strconv.FormatInt(bootTime.Unix(), 10)
+		}
+		return ""
+	})
+
+	s := newTestServer(t, Config{
+		Prometheus: prometheus,
+		Nodes: []NodeConfig{{
+			Name: "hive",
+			FQDN: "hive.example.com",
+			Services: []ServiceConfig{
+				{Name: "Plex", Type: ServiceTypeProbe, Instance: "plex.example.com:32400"},
+				{Name: "Uplink", Type: ServiceTypeSNMP, Interface: "eth0", Capacity: 5000000},
+			},
+		}, {
+			Name: "helios",
+			FQDN: "helios.example.com",
+		}},
+	})
+	return s, queries, bootTime
+}
+
+func TestCharts(t *testing.T) {
+	s, queries, _ := newChartTestServer(t)
+
+	for _, test := range []struct {
+		path, status, label string
+	}{
+		{"/chart/hive/load.svg", "ok", "1.00"},
+		{"/chart/hive/cpu.svg", "warning", "100%"},
+		{"/chart/hive/memory.svg", "ok", "8 GiB"},
+		{"/chart/hive/disk.svg?mountpoint=/home", "error", "100 B"},
+		{"/chart/hive/network.svg", "ok", "8 kbit/s"},
+		{"/chart/hive/latency.svg?service=Plex", "warning", "500 ms"},
+		{"/chart/hive/utilisation.svg?service=Uplink", "warning", "100%"},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			w := s.get(test.path)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Body.String(), "series "+test.status)
+			assert.Contains(t, w.Body.String(), ">"+test.label+"</text>")
+			assert.True(t, strings.HasPrefix(w.Body.String(), `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 1000 270"`))
+		})
+	}
+
+	assert.Contains(t, queries.all(), `avg(irate(node_cpu_seconds_total{fqdn="hive.example.com",mode="idle",monitor="master"}[5m]))`)
+	assert.Contains(t, queries.all(), `node_filesystem_avail_bytes{fqdn="hive.example.com",mountpoint="/home",monitor="master"}`)
+}
+
+func TestChartErrors(t *testing.T) {
+	s, _, _ := newChartTestServer(t)
+
+	for path, code := range map[string]int{
+		"/chart/ares/load.svg":                http.StatusNotFound,
+		"/compare/unknown.svg":                http.StatusNotFound,
+		"/compare/loadavg.svg":                http.StatusBadRequest,
+		"/chart/hive/unknown.svg":             http.StatusNotFound,
+		"/chart/hive/load-1000x250.svg":       http.StatusNotFound,
+		"/chart/hive/load":                    http.StatusNotFound,
+		"/chart/hive/load.gif":                http.StatusNotFound,
+		"/chart/hive/load.json?range=1y":      http.StatusBadRequest,
+		"/chart/hive/latency.svg?service=DNS": http.StatusBadRequest,
+		"/load-hive.svg?range=1y":             http.StatusBadRequest,
+	} {
+		t.Run(path, func(t *testing.T) {
+			assert.Equal(t, code, s.get(path).Code)
+		})
+	}
+}
+
+func TestChartFailureNotCached(t *testing.T) {
+	var available atomic.Bool
+	prometheus, _ := newPrometheusTestConfig(t, func(metric string) string {
+		if !available.Load() {
+			// Fails to decode
+			return "invalid"
+		}
+		return "1"
+	})
+	s := newTestServer(t, Config{
+		Prometheus: prometheus,
+		Nodes:      []NodeConfig{{Name: "hive", FQDN: "hive.example.com"}},
+	})
+
+	for _, path := range []string{"/load-hive.svg", "/chart/hive/load.png"} {
+		t.Run(path, func(t *testing.T) {
+			available.Store(false)
+			w := s.get(path)
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+			// The next request queries again and gets the chart
+			available.Store(true)
+			w = s.get(path)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NotEqual(t, "no-store", w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestChartCacheKey(t *testing.T) {
+	s, queries, _ := newChartTestServer(t)
+
+	assert.Equal(t, http.StatusOK, s.get("/chart/hive/disk.svg?range=24h&mountpoint=/home").Code)
+	recorded := len(queries.all())
+
+	// Unknown parameters and their order don't make a new cache entry
+	for _, path := range []string{
+		"/chart/hive/disk.svg?mountpoint=/home&range=24h",
+		"/chart/hive/disk.svg?range=24h&mountpoint=/home&nocache=1",
+		"/chart/hive/disk.svg?range=24h&mountpoint=/home&mountpoint=/",
+	} {
+		assert.Equal(t, http.StatusOK, s.get(path).Code, path)
+	}
+	assert.Len(t, queries.all(), recorded)
+
+	assert.Equal(t, http.StatusOK, s.get("/chart/hive/disk.svg?range=24h&mountpoint=/").Code)
+	assert.Greater(t, len(queries.all()), recorded)
+}
+
+func TestChartAnnotations(t *testing.T) {
+	s, _, bootTime := newChartTestServer(t)
+
+	// Charts of a node are annotated with thresholds, failed probes, deploys
+	// and reboots
+	w := s.get("/chart/hive/cpu.svg")
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, class := range []string{`class="threshold warning stroke"`, `class="threshold error stroke"`, `class="outage fill"`, `class="marker reboot stroke"`, ">Reboot</text>"} {
+		assert.Contains(t, w.Body.String(), class)
+	}
+
+	s.startTime = time.Now().Add(-30 * time.Minute)
+	assert.Equal(t, []chartMarker{
+		{"Deploy", "deploy", s.startTime},
+		{"Reboot", "reboot", bootTime.Truncate(time.Minute)},
+	}, s.chartMarkers(context.Background(), s.config.Nodes[0], time.Now().Add(-time.Hour), time.Now(), time.Minute))
+
+	// Thresholds above the chart are left out
+	w = s.get("/chart/hive/load.svg")
+	assert.NotContains(t, w.Body.String(), "threshold")
+	assert.Contains(t, w.Body.String(), `class="caption text">Load: min 1.00, mean 1.00, max 1.00, p95 1.00, last 1.00</text>`)
+}
+
+func TestChartSummary(t *testing.T) {
+	s, _, _ := newChartTestServer(t)
+
+	// The summary is available next to the chart
+	w := s.get("/chart/hive/loadavg.json?range=6h")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var summary apiChartSummary
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &summary))
+	assert.Equal(t, "6h", summary.Range)
+	if assert.Len(t, summary.Series, 3) {
+		assert.Equal(t, chartSummary{"15m", 3, 3, 3, 3, 3, 361}, summary.Series[2])
+	}
+
+	samples := make([]Sample, 100)
+	for i := range samples {
+		samples[i] = Sample{time.Unix(int64(i), 0), float64(100 - i)}
+	}
+	assert.Equal(t, chartSummary{"Load", 1, 50.5, 100, 95, 1, 100}, summarize("Load", samples))
+	// Loads below 1 don't count as 0
+	assert.Equal(t, 0.5, summarize("Load", []Sample{{time.Unix(0, 0), 0.25}, {time.Unix(60, 0), 0.75}}).Mean)
+}
+
+func TestChartSeries(t *testing.T) {
+	s, _, _ := newChartTestServer(t)
+
+	t.Run("overlay", func(t *testing.T) {
+		// Overlays have a legend entry and a class per series
+		w := s.get("/chart/hive/loadavg.svg")
+		assert.Equal(t, http.StatusOK, w.Code)
+		for i, name := range []string{"1m", "5m", "15m"} {
+			assert.Contains(t, w.Body.String(), fmt.Sprintf(`class="series series-%d stroke"`, i))
+			assert.Contains(t, w.Body.String(), ">"+name+"</text>")
+		}
+		assert.NotContains(t, w.Body.String(), "series-0 fill")
+	})
+
+	t.Run("compare services", func(t *testing.T) {
+		w := s.get("/compare/latency.svg?service=Plex")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), ">hive</text>")
+		assert.NotContains(t, w.Body.String(), ">helios</text>")
+	})
+
+	t.Run("compare nodes", func(t *testing.T) {
+		w := s.get("/compare/load.svg")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), ">hive</text>")
+		assert.Contains(t, w.Body.String(), ">helios</text>")
+		assert.NotContains(t, w.Body.String(), "marker")
+	})
+
+	t.Run("stacked", func(t *testing.T) {
+		// Stacked areas add up to the total memory
+		w := s.get("/chart/hive/memory-breakdown.svg")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "series series-3 stacked fill")
+		assert.Contains(t, w.Body.String(), ">8 GiB</text>")
+		assert.Equal(t, [][]Sample{{{time.Unix(60, 0), 1}, {time.Unix(120, 0), 2}}, {{time.Unix(60, 0), 4}, {time.Unix(120, 0), 6}}},
+			stackSamples([][]Sample{{{time.Unix(0, 0), 5}, {time.Unix(60, 0), 1}, {time.Unix(120, 0), 2}}, {{time.Unix(60, 0), 3}, {time.Unix(120, 0), 4}}}))
+	})
+
+	t.Run("ranges", func(t *testing.T) {
+		w := s.get("/chart/hive/load.svg?range=7d")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Regexp(t, `>(Mon|Tue|Wed|Thu|Fri|Sat|Sun) \d+</text>`, w.Body.String())
+
+		for name, step := range map[string]time.Duration{"1h": time.Minute, "6h": time.Minute, "24h": 4 * time.Minute, "30d": 2 * time.Hour} {
+			timeRange, ok := chartRangeByName(name)
+			assert.True(t, ok)
+			assert.Equal(t, step, timeRange.step(), name)
+		}
+	})
+}
+
+func TestChartPNG(t *testing.T) {
+	s, _, _ := newChartTestServer(t)
+
+	// PNGs are colored like the SVGs
+	w := s.get("/chart/hive/load.png")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	img, err := png.Decode(w.Body)
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.Rect(0, 0, 1000, 270), img.Bounds())
+		r, g, b, _ := img.At(0, 0).RGBA()
+		assert.Equal(t, []uint32{0x27, 0x27, 0x27}, []uint32{r >> 8, g >> 8, b >> 8})
+	}
+
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	s.messageChart(c, http.StatusOK, "No data.", "png")
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	img, err = png.Decode(w.Body)
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.Rect(0, 0, 1000, 50), img.Bounds())
+	}
+}
+
+func TestChartMessageEscaped(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	messageSVG(c, http.StatusServiceUnavailable, `No data of mountpoint "<script>".`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `No data of mountpoint &#34;&lt;script&gt;&#34;.`)
+	assert.NotContains(t, w.Body.String(), "<script>")
+}
+
+func TestChartAlternatives(t *testing.T) {
+	s, _, _ := newChartTestServer(t)
+
+	// The status page links the ranges and shows their load charts with a
+	// text alternative
+	w := s.get("/status?range=24h")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `<a href="/status?range=24h" class="active">24h</a>`)
+	assert.Contains(t, body, `<img src="/load-hive.svg?range=24h" alt="Load of hive in the last 24 hours: steady around 1.00, between 1.00 and 1.00." width=1000 height=270>`)
+	assert.Equal(t, 2, strings.Count(body, "<summary>Data table</summary>"))
+	assert.Equal(t, 2*(chartTableRows+1), strings.Count(body, "<td>1.00</td>")+strings.Count(body, "<th>Load</th>"))
+	assert.Contains(t, body, `<img class=sparkline src="data:image/svg&#43;xml;base64,`)
+	assert.Contains(t, body, `alt="Latency in the last 24 hours: steady around 500 ms, between 500 ms and 500 ms."`)
+	assert.Contains(t, body, `alt="Utilisation in the last 24 hours: steady around 50%, between 50% and 50%."`)
+
+	sparkline := string(sparklineSVG([]Sample{{time.Unix(0, 0), 0}, {time.Unix(60, 0), 1}}, 1, "error"))
+	svg, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sparkline, "data:image/svg+xml;base64,"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(svg), `stroke="#800000" stroke-width="1.5" points="0.0,19.0 100.0,1.0"`)
+
+	samples := make([]Sample, 120)
+	for i := range samples {
+		samples[i] = Sample{time.Unix(int64(i*60), 0), float64(i % 2)}
+	}
+	if downsampled := downsample(samples, 50); assert.Len(t, downsampled, 50) {
+		assert.Equal(t, Sample{time.Unix(0, 0), 0.5}, downsampled[0])
+	}
+}
+
+func TestChartFormatting(t *testing.T) {
+	for _, test := range []struct {
+		name, expected, actual string
+	}{
+		{"rising trend", "rising from 1.00 to 3.00, between 1.00 and 3.00", describeTrend([]Sample{
+			{time.Unix(0, 0), 1}, {time.Unix(60, 0), 2}, {time.Unix(120, 0), 3},
+		}, chart.FloatValueFormatter)},
+		{"falling trend", "falling from 3 B to 1 B, between 1 B and 3 B", describeTrend([]Sample{
+			{time.Unix(0, 0), 3}, {time.Unix(60, 0), 2}, {time.Unix(120, 0), 1},
+		}, formatBytes)},
+		{"bytes", "1.5 KiB", formatBytes(1536.0)},
+		{"bits per second", "100 Mbit/s", formatBitsPerSecond(100e6)},
+		{"no bits per second", "0 bit/s", formatBitsPerSecond(0)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.actual)
+		})
+	}
+}
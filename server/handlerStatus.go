@@ -3,405 +3,299 @@ package server
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/prometheus/client_golang/api"
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
-	"github.com/prometheus/common/model"
-	drawingUpstream "github.com/wcharczuk/go-chart/drawing"
-
 	"github.com/gin-gonic/gin"
 	"github.com/go-errors/errors"
-	"github.com/hashworks/go-chart"
 )
 
-var svgLoadDimensions = [][]int{
-	{1120, 200},
-	{1020, 200},
-	{920, 200},
-	{820, 200},
-	{720, 200},
-	{620, 200},
-	{520, 200},
-	{440, 200},
-	{750, 200},
-	{600, 200},
-	{380, 200},
-	{200, 115},
-}
+// statusTimeout is the deadline of the queries of the nodes, the chart texts
+// and the sparklines of a status collection
+const statusTimeout = 10 * time.Second
+
+// Thresholds of the status classification, shared with the charts
+const (
+	loadWarningThreshold = 4
+	loadErrorThreshold   = 8
+	// Probe latency in seconds
+	probeLatencyWarningThreshold = 0.2
+)
 
 type Node struct {
-	Name     string
-	Services []Service
-	Loads    []Load
+	Name     string    `json:"name"`
+	Services []Service `json:"services"`
+	Loads    []Load    `json:"loads"`
+	// Reason why the loads are unknown, empty if they were queried successfully
+	Error string `json:"error,omitempty"`
 }
 
 type Service struct {
-	Name    string
-	Status  string
-	Message string
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// A few words for badges, like "online 0.05s"
+	Summary string `json:"summary"`
+	// Only set for probes if a state directory is configured
+	History *ServiceHistory `json:"history,omitempty"`
+}
+
+// Degraded reports whether any data of the node could not be queried
+func (n Node) Degraded() bool {
+	if n.Error != "" {
+		return true
+	}
+	for _, service := range n.Services {
+		if service.Status == "unknown" {
+			return true
+		}
+	}
+	return false
 }
 
 type Load struct {
-	Status string
-	Value  float64
+	// The averaging window, like "1m"
+	Window string  `json:"window"`
+	Status string  `json:"status"`
+	Value  float64 `json:"value"`
 }
 
-func (s *Server) queryPrometheus(query string, ts time.Time) (model.Vector, error) {
-	client, err := api.NewClient(api.Config{
-		Address: "http://127.0.0.1:9090",
-	})
-	if err != nil {
-		return nil, err
-	}
+// unavailableReason logs a failed query with logger and returns the reason
+// shown to visitors
+func (s *Server) unavailableReason(logger *slog.Logger, err error) string {
+	logger.Error(err.Error())
 
-	v1api := v1.NewAPI(client)
+	if s.config.Debug {
+		return err.Error()
+	}
+	switch {
+	case errors.Is(err, ErrNoData):
+		return "No data collected."
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Query timed out."
+	default:
+		return "Query failed."
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// queryNodes queries all configured nodes concurrently. Failed queries
+// are marked as unknown instead of failing the whole page.
+func (s *Server) queryNodes(ctx context.Context) []Node {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
 	defer cancel()
 
-	result, warnings, err := v1api.Query(ctx, query, ts)
-	if len(warnings) > 0 {
-		log.Printf("Prometheus warnings: %v\n", warnings)
-	}
+	nodes := make([]Node, len(s.config.Nodes))
 
-	if result == nil {
-		return nil, errors.New("nil result")
+	var wg sync.WaitGroup
+	for i, nodeConfig := range s.config.Nodes {
+		wg.Add(1)
+		go func(i int, nodeConfig NodeConfig) {
+			defer wg.Done()
+			nodes[i] = s.queryNode(ctx, nodeConfig)
+		}(i, nodeConfig)
 	}
+	wg.Wait()
 
-	return result.(model.Vector), err
+	return nodes
 }
 
-func (s *Server) queryPrometheusRange(query string, r v1.Range) (model.Matrix, error) {
-	client, err := api.NewClient(api.Config{
-		Address: "http://127.0.0.1:9090",
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	v1api := v1.NewAPI(client)
+// queryNode queries the loads and all services of a node concurrently
+func (s *Server) queryNode(ctx context.Context, nodeConfig NodeConfig) Node {
+	source := s.metricsSource(nodeConfig)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	result, warnings, err := v1api.QueryRange(ctx, query, r)
-	if len(warnings) > 0 {
-		log.Printf("Prometheus warnings: %v\n", warnings)
+	var loads []Load
+	var loadsErr error
+	services := make([]Service, len(nodeConfig.Services))
+	servicesErrs := make([]error, len(nodeConfig.Services))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		loads, loadsErr = s.queryLoads(ctx, source, nodeConfig)
+	}()
+	for i, serviceConfig := range nodeConfig.Services {
+		wg.Add(1)
+		go func(i int, serviceConfig ServiceConfig) {
+			defer wg.Done()
+			switch serviceConfig.Type {
+			case ServiceTypeProbe:
+				services[i], servicesErrs[i] = s.queryProbe(ctx, source, nodeConfig, serviceConfig)
+			case ServiceTypeSNMP:
+				services[i], servicesErrs[i] = s.querySNMP(ctx, source, nodeConfig, serviceConfig)
+			}
+		}(i, serviceConfig)
 	}
+	wg.Wait()
 
-	if result == nil {
-		return nil, errors.New("nil result")
+	node := Node{Name: nodeConfig.Name, Services: services, Loads: loads}
+	if loadsErr != nil {
+		node.Error = s.unavailableReason(s.logger, loadsErr)
 	}
-
-	return result.(model.Matrix), err
-}
-
-func (s *Server) queryNode(shortHostname string, fqdn string, plexDomain string, dotDomain string, snmp bool) (Node, error) {
-	load1, err := s.queryPrometheus("node_load1{fqdn=\""+fqdn+"\",monitor=\"master\"}", time.Now())
-	if err != nil || load1.Len() != 1 {
-		return Node{}, errors.New("Prometheus query 'node_load1' for " + shortHostname + " failed.")
+	for i, err := range servicesErrs {
+		if err != nil {
+			node.Services[i] = Service{Name: nodeConfig.Services[i].Name, Status: "unknown", Message: s.unavailableReason(s.logger, err), Summary: "unknown"}
+		}
 	}
 
-	load5, err := s.queryPrometheus("node_load5{fqdn=\""+fqdn+"\",monitor=\"master\"}", time.Now())
-	if err != nil || load5.Len() != 1 {
-		return Node{}, errors.New("Prometheus query 'node_load5' for " + shortHostname + " failed.")
+	// Planned downtimes aren't errors
+	for i, service := range node.Services {
+		if service.Status != "error" {
+			continue
+		}
+		if maintenance, ok := s.announcements.maintenance(nodeConfig.Name, service.Name, time.Now()); ok {
+			node.Services[i].Status = "maintenance"
+			node.Services[i].Summary = "maintenance"
+			if maintenance.End.IsZero() {
+				node.Services[i].Message = "Planned maintenance."
+			} else {
+				node.Services[i].Message = "Planned maintenance until " + maintenance.End.Format(announcementTimeFormat) + "."
+			}
+		}
 	}
 
-	load15, err := s.queryPrometheus("node_load15{fqdn=\""+fqdn+"\",monitor=\"master\"}", time.Now())
-	if err != nil || load15.Len() != 1 {
-		return Node{}, errors.New("Prometheus query 'node_load15' for " + shortHostname + " failed.")
+	return node
+}
+
+func (s *Server) queryLoads(ctx context.Context, source MetricsSource, nodeConfig NodeConfig) ([]Load, error) {
+	metrics := []string{"node_load1", "node_load5", "node_load15"}
+	windows := []string{"1m", "5m", "15m"}
+	values, err := source.Instants(ctx, metrics, map[string]string{"fqdn": nodeConfig.FQDN})
+	if err != nil {
+		return nil, fmt.Errorf("query 'node_load' for %s failed: %w", nodeConfig.Name, err)
 	}
 
 	var loads []Load
 
-	for _, load := range []model.Vector{load1, load5, load15} {
-		value, err := strconv.ParseFloat(load[0].Value.String(), 8)
-		if err != nil {
-			return Node{}, errors.New("Failed to parse load.")
-		}
+	for i, metric := range metrics {
+		value := values[metric]
 		var status string
 
-		if value >= 8 {
+		if value >= loadErrorThreshold {
 			status = "error"
-		} else if value >= 4 {
+		} else if value >= loadWarningThreshold {
 			status = "warning"
 		} else {
 			status = "ok"
 		}
 
 		loads = append(loads, Load{
+			Window: windows[i],
 			Value:  value,
 			Status: status,
 		})
 	}
 
-	var services []Service
-	var probeNames []string
-	var probeSuccess []model.Vector
-	var probeDurations []model.Vector
-
-	if plexDomain != "" {
-		probeSuccessPlex, err := s.queryPrometheus("probe_success{instance=\""+plexDomain+":32400\",monitor=\"master\"}", time.Now())
-		if err != nil || probeSuccessPlex.Len() != 1 {
-			return Node{}, errors.New("Prometheus query 'probe_success' for plex on " + shortHostname + " failed.")
-		}
-
-		probeDurationPlex, err := s.queryPrometheus("probe_duration_seconds{instance=\""+plexDomain+":32400\",monitor=\"master\"}", time.Now())
-		if err != nil || probeDurationPlex.Len() != 1 {
-			return Node{}, errors.New("Prometheus query 'probe_duration_seconds' for plex on " + shortHostname + " failed.")
-		}
+	return loads, nil
+}
 
-		probeNames = append(probeNames, "Plex")
-		probeSuccess = append(probeSuccess, probeSuccessPlex)
-		probeDurations = append(probeDurations, probeDurationPlex)
+func (s *Server) queryProbe(ctx context.Context, source MetricsSource, nodeConfig NodeConfig, serviceConfig ServiceConfig) (Service, error) {
+	values, err := source.Instants(ctx, []string{"probe_success", "probe_duration_seconds"}, map[string]string{"instance": serviceConfig.Instance})
+	if err != nil {
+		return Service{}, fmt.Errorf("query 'probe' for %s on %s failed: %w", serviceConfig.Name, nodeConfig.Name, err)
 	}
+	probeSuccess, probeDuration := values["probe_success"], values["probe_duration_seconds"]
 
-	if dotDomain != "" {
-		probeSuccessDoT, err := s.queryPrometheus("probe_success{instance=\""+dotDomain+":853\",monitor=\"master\"}", time.Now())
-		if err != nil || probeSuccessDoT.Len() != 1 {
-			return Node{}, errors.New("Prometheus query 'probe_success' for dot on " + shortHostname + " failed.")
-		}
+	service := Service{Name: serviceConfig.Name, Status: "error", Message: "No data!", Summary: "no data"}
 
-		probeDurationDoT, err := s.queryPrometheus("probe_duration_seconds{instance=\""+dotDomain+":853\",monitor=\"master\"}", time.Now())
-		if err != nil || probeDurationDoT.Len() != 1 {
-			return Node{}, errors.New("Prometheus query 'probe_duration_seconds' for dot on " + shortHostname + " failed.")
+	if probeSuccess != 1 {
+		service.Status = "error"
+		service.Message = "Offline."
+		service.Summary = "offline"
+	} else {
+		if probeDuration >= probeLatencyWarningThreshold {
+			service.Status = "warning"
+		} else {
+			service.Status = "ok"
 		}
-
-		probeNames = append(probeNames, "DNS")
-		probeSuccess = append(probeSuccess, probeSuccessDoT)
-		probeDurations = append(probeDurations, probeDurationDoT)
+		service.Message = fmt.Sprintf("Online. %.02fs latency.", probeDuration)
+		service.Summary = fmt.Sprintf("online %.02fs", probeDuration)
 	}
 
-	for i, probeDuration := range probeDurations {
-		newService := Service{probeNames[i], "error", "No data!"}
+	return service, nil
+}
+
+func (s *Server) querySNMP(ctx context.Context, source MetricsSource, nodeConfig NodeConfig, serviceConfig ServiceConfig) (Service, error) {
+	outRate, err := source.Instant(ctx, serviceConfig.snmpQuery())
+	if err != nil {
+		return Service{}, fmt.Errorf("query 'ifHCOutOctets' for %s failed: %w", nodeConfig.Name, err)
+	}
 
-		if probeSuccess[i][0].Value.String() != "1" {
-			newService.Status = "error"
-			newService.Message = "Offline."
+	service := Service{Name: serviceConfig.Name, Status: "error", Message: "No data!", Summary: "no data"}
+	if outRate != 0 {
+		percentage := int(math.Min(outRate/serviceConfig.Capacity*100, 100))
+		service.Message = fmt.Sprintf("%d%% average utilisation over the last 5 minutes", percentage)
+		service.Summary = fmt.Sprintf("%d%% utilisation", percentage)
+		if percentage > 90 {
+			service.Status = "error"
+		} else if percentage > 50 {
+			service.Status = "warning"
 		} else {
-			probeDuration, err := strconv.ParseFloat(probeDuration[0].Value.String(), 8)
-			if err != nil {
-				return Node{}, errors.New("Failed to parse probe duration.")
-			}
-			if probeDuration >= 0.2 {
-				newService.Status = "warning"
-			} else {
-				newService.Status = "ok"
-			}
-			newService.Message = fmt.Sprintf("Online. %.02fs latency.", probeDuration)
+			service.Status = "ok"
 		}
-
-		services = append(services, newService)
 	}
 
-	if snmp {
-		outRate, err := s.queryPrometheus("irate(ifHCOutOctets{job=\"snmp\",ifName=\"eth0\",monitor=\"master\"}[5m])", time.Now())
-		if err != nil || outRate.Len() != 1 {
-			return Node{}, errors.New("Prometheus query 'ifHCOutOctets' for " + shortHostname + " failed.")
-		}
-		outRateValue, err := strconv.ParseFloat(outRate[0].Value.String(), 8)
-		if err != nil {
-			return Node{}, errors.New("Failed to parse outRate.")
-		}
+	return service, nil
+}
 
-		newService := Service{"Upstream Load", "error", "No data!"}
-		if outRateValue != 0 {
-			percentage := int(math.Min(outRateValue/float64(50000*1000)*100, 100))
-			newService.Message = fmt.Sprintf("%d%% average utilisation over the last 5 minutes", percentage)
-			if percentage > 90 {
-				newService.Status = "error"
-			} else if percentage > 50 {
-				newService.Status = "warning"
-			} else {
-				newService.Status = "ok"
-			}
+// statusHeaders sets the headers shared by the status page and the API and
+// reports whether any of the nodes is degraded
+func (s *Server) statusHeaders(c *gin.Context, snapshotTime time.Time, nodes []Node) bool {
+	degraded := false
+	for _, node := range nodes {
+		if node.Degraded() {
+			degraded = true
+			c.Header("X-Status-Degraded", "true")
+			break
 		}
-		services = append(services, newService)
 	}
 
-	return Node{shortHostname, services, loads}, nil
+	c.Header("Cache-Control", fmt.Sprintf("max-age=%d", int(s.config.StatusInterval.Seconds())))
+	c.Header("Last-Modified", snapshotTime.Format(time.RFC1123))
+
+	return degraded
 }
 
 func (s *Server) handlerStatus(c *gin.Context) {
 	pageStartTime := time.Now()
 
-	nodeHive, err := s.queryNode("hive", "hive.hashworks.net", "plex.hive.hashworks.net", "", true)
-	if err != nil {
-		s.recoveryHandlerStatus(http.StatusInternalServerError, c, err)
+	snapshot, ok := s.statusSnapshot(c.Request.Context())
+	if !ok {
+		s.recoveryHandlerStatus(http.StatusServiceUnavailable, c, errors.New("No status collected yet."))
 		return
 	}
 
-	nodeHelios, err := s.queryNode("helios", "helios.kromlinger.eu", "", "dns.kromlinger.eu", false)
-	if err != nil {
-		s.recoveryHandlerStatus(http.StatusInternalServerError, c, err)
+	degraded := s.statusHeaders(c, snapshot.Time, snapshot.Nodes)
+	announcements := s.announcements.current(time.Now())
+
+	c.Header("Vary", "Accept")
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, newAPIStatus(snapshot, degraded, announcements))
 		return
 	}
 
-	c.Header("Cache-Control", "max-age=60")
-	c.Header("Last-Modified", time.Now().Format(time.RFC1123))
+	// Unknown ranges fall back to the default, it's just a link parameter
+	timeRange, ok := chartRangeByName(c.Query("range"))
+	if !ok {
+		timeRange = chartRanges[0]
+	}
+
 	c.Header("Link", "</css/status.css>; rel=preload; as=style")
 	c.HTML(http.StatusOK, "status", gin.H{
+		"ChartRange":    timeRange.Name,
+		"ChartRanges":   chartRanges,
+		"Charts":        snapshot.Charts[timeRange.Name],
+		"Sparklines":    snapshot.Sparklines,
 		"Title":         "status",
 		"Description":   "Status information.",
 		"StatusTab":     true,
 		"PageStartTime": pageStartTime,
-		"Nodes":         []Node{nodeHive, nodeHelios},
+		"Nodes":         snapshot.Nodes,
+		"Degraded":      degraded,
+		"Announcements": announcements,
+		"SnapshotAge":   time.Since(snapshot.Time).Round(time.Second),
 	})
 }
-
-func (s *Server) drawChart(c *gin.Context, graph chart.Chart) {
-	c.Header("Content-Type", chart.ContentTypeSVG)
-	c.Header("Cache-Control", "max-age=600")
-	c.Header("Last-Modified", time.Now().Format(time.RFC1123))
-
-	if err := graph.Render(chart.SVGWithCSS(s.chartCSS, ""), c.Writer); err != nil {
-		log.Printf("%s - Error: %s", time.Now().Format(time.RFC3339), err.Error())
-		c.AbortWithStatus(500)
-		return
-	}
-
-	c.Status(200)
-}
-
-func (s *Server) handlerLoadSVG(hostname string, width, height int) func(*gin.Context) {
-	return func(c *gin.Context) {
-		values, err := s.queryPrometheusRange("node_load1{fqdn=\""+hostname+"\",monitor=\"master\"}", v1.Range{
-			Start: time.Now().Add(-time.Hour),
-			End:   time.Now(),
-			Step:  time.Minute,
-		})
-		if err != nil || values.Len() != 1 {
-			log.Println(values)
-			s.recoveryHandlerStatus(http.StatusInternalServerError, c, errors.New("Prometheus query 'node_load1' for "+hostname+" failed."))
-			return
-		}
-
-		if len(values[0].Values) < 2 {
-			messageSVG(c, "Not enough data collected in the last hour to draw a graph.", width)
-			return
-		}
-
-		short := chart.TimeSeries{
-			Name: "Short",
-			Style: chart.Style{
-				Show:      true,
-				ClassName: "series",
-				FillColor: drawingUpstream.ColorBlack, // Dummy-Fill so go-chart produces the fill-paths
-			},
-			XValues: []time.Time{},
-			YValues: []float64{},
-		}
-
-		var max float64
-		avg := 0
-		count := 0 // Since len(…) could be wrong
-
-		for _, samplePair := range values[0].Values {
-			load, err := strconv.ParseFloat(samplePair.Value.String(), 8)
-			if err != nil {
-				s.recoveryHandlerStatus(http.StatusInternalServerError, c, errors.New("Failed to parse load."))
-				return
-			}
-
-			short.XValues = append(short.XValues, samplePair.Timestamp.Time())
-			short.YValues = append(short.YValues, load)
-
-			if load > max {
-				max = load
-			}
-
-			avg += int(load)
-			count++
-		}
-
-		avg /= count
-
-		var statusClass string
-		if avg >= 8 {
-			statusClass = "error"
-		} else if avg >= 4 {
-			statusClass = "warning"
-		} else {
-			statusClass = "ok"
-		}
-		short.Style.ClassName += " " + statusClass
-
-		graph := chart.Chart{
-			Height: int(height),
-			Width:  int(width),
-			Background: chart.Style{
-				ClassName: "bg",
-			},
-			Canvas: chart.Style{
-				ClassName: "bg",
-			},
-			XAxis: chart.XAxis{
-				Style: chart.Style{
-					ClassName: "axis",
-					Show:      true,
-				},
-				ValueFormatter: chart.TimeValueFormatterWithFormat("15:04"),
-			},
-			YAxis: chart.YAxis{
-				Range: &chart.ContinuousRange{Min: 0, Max: max},
-				Style: chart.Style{
-					ClassName: "axis",
-					Show:      true,
-				},
-				ValueFormatter: chart.FloatValueFormatter,
-			},
-			Series: []chart.Series{short},
-		}
-
-		graph.Elements = []chart.Renderable{
-			chart.Legend(&graph, chart.Style{
-				ClassName: "legend " + statusClass,
-			}),
-		}
-
-		s.drawChart(c, graph)
-	}
-}
-
-func messageSVG(c *gin.Context, message string, width int) {
-	var messages []string
-	width += 100
-	charactersPerLine := width / 15
-	if len(message) <= charactersPerLine {
-		messages = append(messages, `<tspan x="0" dy="30">`+strings.TrimSpace(message)+`</tspan>`)
-	} else {
-		words := strings.Fields(strings.TrimSpace(message))
-		charactersLeft := charactersPerLine
-		line := ""
-		for _, word := range words {
-			wordLength := len(word)
-			if charactersLeft > 0 && (wordLength <= charactersLeft || wordLength > charactersPerLine) {
-				line += word + " "
-				charactersLeft -= wordLength
-			} else {
-				messages = append(messages, `<tspan x="0" dy="30">`+strings.TrimSpace(line)+`</tspan>`)
-				line = word + " "
-				charactersLeft = charactersPerLine - wordLength
-			}
-		}
-		line = strings.TrimSpace(line)
-		if line != "" {
-			messages = append(messages, `<tspan x="0" dy="30">`+line+`</tspan>`)
-		}
-	}
-	width += 10
-	height := len(messages)*30 + 20
-
-	c.Header("Content-Type", "image/svg+xml")
-	c.Header("Cache-Control", "no-store")
-	c.String(200, fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%d" height="%d">`+
-		`<rect width="100%%" height="100%%" fill="#272727"/>`+
-		`<text x="0" y="0" fill="white" font-size="24" font-family="sans-serif">%s</text>`+
-		`</svg>`, width, height, strings.Join(messages, "")))
-}
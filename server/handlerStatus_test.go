@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusPartialFailure(t *testing.T) {
+	prometheus, _ := newPrometheusTestConfig(t, func(metric string) string {
+		switch metric {
+		case "ifHCOutOctets":
+			return ""
+		case "probe_duration_seconds":
+			return "0.05"
+		}
+		return "1"
+	})
+
+	s := newTestServer(t, Config{
+		Prometheus: prometheus,
+		Nodes: []NodeConfig{{
+			Name: "hive",
+			FQDN: "hive.example.com",
+			Services: []ServiceConfig{
+				{Name: "Plex", Type: ServiceTypeProbe, Instance: "plex.example.com:32400"},
+				{Name: "Upstream Load", Type: ServiceTypeSNMP, Interface: "eth0", Capacity: 1000},
+			},
+		}},
+	})
+	w := s.get("/status")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Status-Degraded"))
+	body := w.Body.String()
+	assert.Contains(t, body, "Some data is currently unavailable.")
+	assert.Contains(t, body, `<td class="ok">1.00</td>`)
+	assert.Contains(t, body, `<div class="status ok">Online. 0.05s latency.</div>`)
+	assert.Contains(t, body, `<div class="status unknown">No data collected.</div>`)
+}
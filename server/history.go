@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// historyRetention is the time range shown by the history bar
+const historyRetention = 30 * 24 * time.Hour
+
+// Probe results are aggregated per hour, keyed by the start of the hour
+const historyResolution = time.Hour
+
+var uptimeRanges = []struct {
+	Label    string
+	Duration time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// ServiceHistory is the uptime of a service in the past
+type ServiceHistory struct {
+	Uptimes []Uptime     `json:"uptimes"`
+	Days    []HistoryDay `json:"days"`
+}
+
+type Uptime struct {
+	Label      string  `json:"label"`
+	Percentage float64 `json:"percentage"`
+	// False if no probe results were recorded in the time range
+	Known bool `json:"known"`
+}
+
+type HistoryDay struct {
+	Date       time.Time `json:"date"`
+	Status     string    `json:"status"`
+	Percentage float64   `json:"percentage"`
+	Known      bool      `json:"known"`
+}
+
+// history records probe results in a bbolt database, one bucket per service
+type history struct {
+	path string
+	db   *bolt.DB
+}
+
+func openHistory(stateDir string) (*history, error) {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, err
+	}
+
+	h := &history{path: filepath.Join(stateDir, "history.db")}
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// open opens the database, again after close. Only one process may have it
+// open at a time.
+func (h *history) open() error {
+	db, err := bolt.Open(h.path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	h.db = db
+	return nil
+}
+
+func (h *history) close() error {
+	return h.db.Close()
+}
+
+func historyBucket(node, service string) []byte {
+	return []byte(node + "/" + service)
+}
+
+func historyKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.Truncate(historyResolution).Unix()))
+	return key
+}
+
+// record adds a probe result to the hour of t and removes expired hours
+func (h *history) record(node, service string, t time.Time, up bool) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(historyBucket(node, service))
+		if err != nil {
+			return err
+		}
+
+		key := historyKey(t)
+		value := make([]byte, 8)
+		if existing := bucket.Get(key); len(existing) == 8 {
+			copy(value, existing)
+		}
+		if up {
+			binary.BigEndian.PutUint32(value[:4], binary.BigEndian.Uint32(value[:4])+1)
+		}
+		binary.BigEndian.PutUint32(value[4:], binary.BigEndian.Uint32(value[4:])+1)
+		if err := bucket.Put(key, value); err != nil {
+			return err
+		}
+
+		// Cursors may return unexpected keys after changes, so the expired
+		// keys are deleted after iterating
+		expired := historyKey(t.Add(-historyRetention - 24*time.Hour))
+		var expiredKeys [][]byte
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil && string(k) < string(expired); k, _ = cursor.Next() {
+			expiredKeys = append(expiredKeys, append([]byte(nil), k...))
+		}
+		for _, k := range expiredKeys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// serviceHistory returns the uptimes and the per-day history up to now
+func (h *history) serviceHistory(node, service string, now time.Time) (ServiceHistory, error) {
+	type counts struct{ up, total uint32 }
+	hours := make(map[int64]counts)
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket(node, service))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(historyKey(now.Add(-historyRetention))); k != nil; k, v = cursor.Next() {
+			if len(k) != 8 || len(v) != 8 {
+				continue
+			}
+			hours[int64(binary.BigEndian.Uint64(k))] = counts{binary.BigEndian.Uint32(v[:4]), binary.BigEndian.Uint32(v[4:])}
+		}
+		return nil
+	})
+	if err != nil {
+		return ServiceHistory{}, err
+	}
+
+	sum := func(start, end time.Time) (float64, bool) {
+		var up, total uint32
+		for hour, c := range hours {
+			if hour >= start.Truncate(historyResolution).Unix() && hour < end.Unix() {
+				up += c.up
+				total += c.total
+			}
+		}
+		if total == 0 {
+			return 0, false
+		}
+		return float64(up) / float64(total) * 100, true
+	}
+
+	var serviceHistory ServiceHistory
+
+	for _, uptimeRange := range uptimeRanges {
+		percentage, known := sum(now.Add(-uptimeRange.Duration), now)
+		serviceHistory.Uptimes = append(serviceHistory.Uptimes, Uptime{uptimeRange.Label, percentage, known})
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for day := int(historyRetention/(24*time.Hour)) - 1; day >= 0; day-- {
+		start := today.AddDate(0, 0, -day)
+		percentage, known := sum(start, start.AddDate(0, 0, 1))
+		serviceHistory.Days = append(serviceHistory.Days, HistoryDay{start, uptimeStatus(percentage, known), percentage, known})
+	}
+
+	return serviceHistory, nil
+}
+
+func uptimeStatus(percentage float64, known bool) string {
+	if !known {
+		return "unknown"
+	} else if percentage >= 99 {
+		return "ok"
+	} else if percentage >= 90 {
+		return "warning"
+	}
+	return "error"
+}
+
+// recordHistory records the probe results of all nodes and adds their history
+func (s *Server) recordHistory(nodes []Node, now time.Time) {
+	for i, nodeConfig := range s.config.Nodes {
+		for j, serviceConfig := range nodeConfig.Services {
+			if serviceConfig.Type != ServiceTypeProbe {
+				continue
+			}
+			service := &nodes[i].Services[j]
+
+			// Maintenance windows don't count against the uptime
+			if service.Status != "unknown" && service.Status != "maintenance" {
+				if err := s.history.record(nodeConfig.Name, serviceConfig.Name, now, service.Status != "error"); err != nil {
+					s.logger.Error(err.Error())
+				}
+			}
+
+			serviceHistory, err := s.history.serviceHistory(nodeConfig.Name, serviceConfig.Name, now)
+			if err != nil {
+				s.logger.Error(err.Error())
+				continue
+			}
+			service.History = &serviceHistory
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHistory(t *testing.T) {
+	h, err := openHistory(t.TempDir())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer h.close()
+
+	now := time.Date(2022, 3, 20, 12, 30, 0, 0, time.UTC)
+
+	// Expired, removed by the later records
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.record("hive", "Plex", now.AddDate(0, 0, -60).Add(time.Duration(i)*time.Hour), false))
+	}
+	// Today: 3 of 4 probes up
+	for i, up := range []bool{true, true, false, true} {
+		assert.NoError(t, h.record("hive", "Plex", now.Add(-time.Duration(i)*time.Minute), up))
+	}
+	// 3 days ago: down
+	assert.NoError(t, h.record("hive", "Plex", now.AddDate(0, 0, -3), false))
+	// 20 days ago: up
+	assert.NoError(t, h.record("hive", "Plex", now.AddDate(0, 0, -20), true))
+	// Other services don't count
+	assert.NoError(t, h.record("hive", "DNS", now, false))
+
+	history, err := h.serviceHistory("hive", "Plex", now)
+	assert.NoError(t, err)
+
+	// Today, 3 and 20 days ago
+	assert.NoError(t, h.db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, 3, tx.Bucket(historyBucket("hive", "Plex")).Stats().KeyN)
+		return nil
+	}))
+
+	assert.Equal(t, []Uptime{
+		{"24h", 75, true},
+		{"7d", 60, true},
+		{"30d", float64(4) / 6 * 100, true},
+	}, history.Uptimes)
+
+	if assert.Len(t, history.Days, 30) {
+		assert.Equal(t, HistoryDay{time.Date(2022, 3, 20, 0, 0, 0, 0, time.UTC), "error", 75, true}, history.Days[29])
+		assert.Equal(t, "error", history.Days[26].Status)
+		assert.Equal(t, "ok", history.Days[9].Status)
+		assert.Equal(t, "unknown", history.Days[0].Status)
+	}
+
+	history, err = h.serviceHistory("helios", "Plex", now)
+	assert.NoError(t, err)
+	assert.Equal(t, Uptime{"24h", 0, false}, history.Uptimes[0])
+}
+
+func TestStatusHistory(t *testing.T) {
+	prometheus, _ := newPrometheusTestConfig(t, func(metric string) string {
+		return "1"
+	})
+
+	config := Config{
+		Debug:      true,
+		StateDir:   t.TempDir(),
+		Prometheus: prometheus,
+		Nodes: []NodeConfig{{
+			Name:     "hive",
+			FQDN:     "hive.example.com",
+			Services: []ServiceConfig{{Name: "Plex", Type: ServiceTypeProbe, Instance: "plex.example.com:32400"}},
+		}},
+	}
+
+	s := newTestServer(t, config)
+	w := s.get("/status")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<span>24h: 100.00%</span>")
+	assert.Contains(t, w.Body.String(), "<span>30d: 100.00%</span>")
+	assert.Contains(t, w.Body.String(), `<span class="ok" title="`+time.Now().Format("2006-01-02")+`: 100.00% uptime"></span>`)
+	assert.NoError(t, s.Close())
+
+	// The database is released on close
+	s = newTestServer(t, config)
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/config"
+)
+
+// InfluxDBConfig describes how to reach an InfluxDB using InfluxQL over its
+// HTTP API. InfluxDB 2 can be used with its v1 compatibility API, passing the
+// token as basic_auth password. Authentication and TLS use the same keys as
+// the Prometheus configuration.
+type InfluxDBConfig struct {
+	Address  string        `yaml:"address"`
+	Database string        `yaml:"database"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// Maps Prometheus metric names to InfluxDB measurements and fields,
+	// merged with the Telegraf defaults
+	Metrics map[string]InfluxDBMetric `yaml:"metrics"`
+	// Maps Prometheus label names to InfluxDB tags, merged with the Telegraf
+	// defaults. Labels mapped to an empty string are ignored.
+	Tags map[string]string `yaml:"tags"`
+
+	HTTPClientConfig config.HTTPClientConfig `yaml:",inline"`
+}
+
+type InfluxDBMetric struct {
+	Measurement string `yaml:"measurement"`
+	Field       string `yaml:"field"`
+}
+
+var defaultInfluxDBMetrics = map[string]InfluxDBMetric{
+	"node_load1":    {"system", "load1"},
+	"node_load5":    {"system", "load5"},
+	"node_load15":   {"system", "load15"},
+	"ifHCOutOctets": {"interface", "ifHCOutOctets"},
+
+	"node_boot_time_seconds":            {"kernel", "boot_time"},
+	"node_memory_MemTotal_bytes":        {"mem", "total"},
+	"node_memory_MemAvailable_bytes":    {"mem", "available"},
+	"node_memory_MemFree_bytes":         {"mem", "free"},
+	"node_memory_Buffers_bytes":         {"mem", "buffered"},
+	"node_memory_Cached_bytes":          {"mem", "cached"},
+	"node_filesystem_size_bytes":        {"disk", "total"},
+	"node_filesystem_avail_bytes":       {"disk", "free"},
+	"node_network_transmit_bytes_total": {"net", "bytes_sent"},
+	"node_network_receive_bytes_total":  {"net", "bytes_recv"},
+	// Needs collect_cpu_time of the cpu input, the field is the idle mode
+	"node_cpu_seconds_total": {"cpu", "time_idle"},
+}
+
+// defaultInfluxDBConditions are added to the queries of a metric, to leave out
+// series Prometheus doesn't have. The total of the cpu input adds up all CPUs.
+var defaultInfluxDBConditions = map[string]string{
+	"node_cpu_seconds_total": `"cpu" != 'cpu-total'`,
+}
+
+var defaultInfluxDBTags = map[string]string{
+	"fqdn":       "host",
+	"job":        "",
+	"mountpoint": "path",
+	"device":     "interface",
+	"mode":       "",
+}
+
+func (c *InfluxDBConfig) validate() error {
+	if c.Database == "" {
+		return fmt.Errorf("no database")
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	metrics := make(map[string]InfluxDBMetric, len(defaultInfluxDBMetrics)+len(c.Metrics))
+	for name, metric := range defaultInfluxDBMetrics {
+		metrics[name] = metric
+	}
+	for name, metric := range c.Metrics {
+		if metric.Measurement == "" || metric.Field == "" {
+			return fmt.Errorf("metric '%s' needs a measurement and a field", name)
+		}
+		metrics[name] = metric
+	}
+	c.Metrics = metrics
+
+	tags := make(map[string]string, len(defaultInfluxDBTags)+len(c.Tags))
+	for label, tag := range defaultInfluxDBTags {
+		tags[label] = tag
+	}
+	for label, tag := range c.Tags {
+		tags[label] = tag
+	}
+	c.Tags = tags
+
+	return c.HTTPClientConfig.Validate()
+}
+
+// influxDBSource queries an InfluxDB using InfluxQL
+type influxDBSource struct {
+	client *http.Client
+	config InfluxDBConfig
+}
+
+func newInfluxDBSource(influxDBConfig InfluxDBConfig) (*influxDBSource, error) {
+	client, err := config.NewClientFromConfig(influxDBConfig.HTTPClientConfig, "influxdb", false)
+	if err != nil {
+		return nil, err
+	}
+	return &influxDBSource{client, influxDBConfig}, nil
+}
+
+func quoteInfluxQLIdentifier(identifier string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(identifier) + `"`
+}
+
+func quoteInfluxQLString(value string) string {
+	return `'` + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + `'`
+}
+
+// conditions returns the WHERE clause of a metric matching the tags of the
+// given labels in the time range
+func (i *influxDBSource) conditions(metric string, labels map[string]string, start, end time.Time) string {
+	conditions := []string{
+		fmt.Sprintf("time >= %ds", start.Unix()),
+		fmt.Sprintf("time <= %ds", end.Unix()),
+	}
+	if condition, ok := defaultInfluxDBConditions[metric]; ok {
+		conditions = append(conditions, condition)
+	}
+	for _, label := range sortedKeys(labels) {
+		tag, ok := i.config.Tags[label]
+		if !ok {
+			tag = label
+		}
+		if tag == "" {
+			continue
+		}
+		conditions = append(conditions, quoteInfluxQLIdentifier(tag)+" = "+quoteInfluxQLString(labels[label]))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// influxQL returns an InfluxQL query for the given metric in the time range,
+// optionally grouped by step
+func (i *influxDBSource) influxQL(query MetricQuery, start, end time.Time, step time.Duration) (string, error) {
+	metric, ok := i.config.Metrics[query.Metric]
+	if !ok {
+		return "", fmt.Errorf("metric '%s' is not mapped to an InfluxDB measurement", query.Metric)
+	}
+
+	// Series are grouped by their tags, several matching ones are no answer
+	// like in Prometheus. Averaged queries combine them instead.
+	var groups []string
+	aggregation := "last"
+	if step > 0 || query.Average {
+		aggregation = "mean"
+	}
+	selector := fmt.Sprintf("%s(%s)", aggregation, quoteInfluxQLIdentifier(metric.Field))
+	if query.Rate {
+		selector = fmt.Sprintf("non_negative_derivative(%s, 1s)", selector)
+		// Derivatives need to be grouped by time
+		if step <= 0 {
+			step = time.Minute
+		}
+	}
+
+	if step > 0 {
+		groups = append(groups, fmt.Sprintf("time(%ds)", int64(step.Seconds())))
+	}
+	if !query.Average {
+		groups = append(groups, "*")
+	}
+
+	influxQL := fmt.Sprintf("SELECT %s FROM %s WHERE %s", selector, quoteInfluxQLIdentifier(metric.Measurement), i.conditions(query.Metric, query.Labels, start, end))
+	if len(groups) > 0 {
+		influxQL += " GROUP BY " + strings.Join(groups, ", ")
+	}
+	if step > 0 {
+		influxQL += " fill(none)"
+	}
+
+	return influxQL, nil
+}
+
+type influxDBResponse struct {
+	Results []struct {
+		Series []struct {
+			Values [][]*float64 `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+// query returns the rows of a query of metric matching a single series, the
+// first column is the time
+func (i *influxDBSource) query(ctx context.Context, metric, influxQL string) ([][]*float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, i.config.Timeout)
+	defer cancel()
+
+	parameters := url.Values{}
+	parameters.Set("db", i.config.Database)
+	parameters.Set("q", influxQL)
+	parameters.Set("epoch", "s")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(i.config.Address, "/")+"/query?"+parameters.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := i.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var result influxDBResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode InfluxDB response (%s): %w", response.Status, err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("InfluxDB error: %s", result.Error)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("InfluxDB responded with %s", response.Status)
+	}
+	if len(result.Results) != 1 {
+		return nil, fmt.Errorf("InfluxDB returned %d results", len(result.Results))
+	}
+	if result.Results[0].Error != "" {
+		return nil, fmt.Errorf("InfluxDB error: %s", result.Results[0].Error)
+	}
+	if len(result.Results[0].Series) != 1 {
+		return nil, seriesError(metric, len(result.Results[0].Series))
+	}
+
+	return result.Results[0].Series[0].Values, nil
+}
+
+func (i *influxDBSource) samples(ctx context.Context, metric, influxQL string) ([]Sample, error) {
+	rows, err := i.query(ctx, metric, influxQL)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for _, row := range rows {
+		if len(row) != 2 || row[0] == nil || row[1] == nil {
+			continue
+		}
+		samples = append(samples, Sample{time.Unix(int64(*row[0]), 0), *row[1]})
+	}
+
+	return samples, nil
+}
+
+func (i *influxDBSource) Instant(ctx context.Context, query MetricQuery) (float64, error) {
+	// Same lookback as Prometheus
+	now := time.Now()
+	influxQL, err := i.influxQL(query, now.Add(-5*time.Minute), now, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	samples, err := i.samples(ctx, query.Metric, influxQL)
+	if err != nil {
+		return 0, err
+	}
+	if len(samples) == 0 {
+		return 0, ErrNoData
+	}
+
+	return samples[len(samples)-1].Value, nil
+}
+
+func (i *influxDBSource) Range(ctx context.Context, query MetricQuery, start, end time.Time, step time.Duration) ([]Sample, error) {
+	influxQL, err := i.influxQL(query, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.samples(ctx, query.Metric, influxQL)
+}
+
+// Instants queries all metrics at once if they are stored in the same
+// measurement and one after another otherwise
+func (i *influxDBSource) Instants(ctx context.Context, metrics []string, labels map[string]string) (map[string]float64, error) {
+	var measurement string
+	var selectors []string
+	for _, name := range metrics {
+		metric, ok := i.config.Metrics[name]
+		if !ok {
+			return nil, fmt.Errorf("metric '%s' is not mapped to an InfluxDB measurement", name)
+		}
+		if measurement != "" && measurement != metric.Measurement {
+			return i.instantsSequential(ctx, metrics, labels)
+		}
+		measurement = metric.Measurement
+		selectors = append(selectors, fmt.Sprintf("last(%s) AS %s", quoteInfluxQLIdentifier(metric.Field), quoteInfluxQLIdentifier(name)))
+	}
+
+	now := time.Now()
+	rows, err := i.query(ctx, strings.Join(metrics, ", "), fmt.Sprintf("SELECT %s FROM %s WHERE %s GROUP BY *",
+		strings.Join(selectors, ", "), quoteInfluxQLIdentifier(measurement), i.conditions("", labels, now.Add(-5*time.Minute), now)))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 || len(rows[0]) != len(metrics)+1 {
+		return nil, ErrNoData
+	}
+
+	values := make(map[string]float64, len(metrics))
+	for j, metric := range metrics {
+		if rows[0][j+1] == nil {
+			return nil, ErrNoData
+		}
+		values[metric] = *rows[0][j+1]
+	}
+
+	return values, nil
+}
+
+func (i *influxDBSource) instantsSequential(ctx context.Context, metrics []string, labels map[string]string) (map[string]float64, error) {
+	values := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		value, err := i.Instant(ctx, MetricQuery{Metric: metric, Labels: labels})
+		if err != nil {
+			return nil, err
+		}
+		values[metric] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxDBSource(t *testing.T) {
+	queries := &queryRecorder{}
+	influxDB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query" || r.FormValue("db") != "telegraf" || r.FormValue("epoch") != "s" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query := r.FormValue("q")
+		queries.record(query)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(query, `"missing"`) {
+			_, _ = fmt.Fprint(w, `{"results":[{"statement_id":0}]}`)
+			return
+		}
+		now := time.Now().Unix()
+		if strings.Contains(query, `"several"`) {
+			_, _ = fmt.Fprintf(w, `{"results":[{"statement_id":0,"series":[{"name":"several","tags":{"host":"a"},"values":[[%d,1]]},{"name":"several","tags":{"host":"b"},"values":[[%d,2]]}]}]}`, now, now)
+			return
+		}
+		if columns := strings.Count(query, "last("); columns > 1 {
+			_, _ = fmt.Fprintf(w, `{"results":[{"statement_id":0,"series":[{"name":"system","values":[[%d%s]]}]}]}`, now, strings.Repeat(",1", columns))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"results":[{"statement_id":0,"series":[{"name":"system","columns":["time","value"],"values":[[%d,0.5],[%d,null],[%d,1]]}]}]}`, now-120, now-60, now)
+	}))
+	defer influxDB.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(path, []byte(`influxdb:
+  address: `+influxDB.URL+`
+  database: telegraf
+  metrics:
+    probe_success: {measurement: blackbox, field: success}
+    probe_duration_seconds: {measurement: blackbox, field: duration}
+    missing_metric: {measurement: missing, field: value}
+    several_metric: {measurement: several, field: value}
+  tags:
+    instance: server
+nodes:
+  - name: hive
+    fqdn: hive.example.com
+    source: influxdb
+    services:
+      - name: Plex
+        type: probe
+        instance: plex.example.com:32400
+`), 0600)
+	assert.NoError(t, err)
+
+	config := Config{Debug: true}
+	if !assert.NoError(t, config.LoadFile(path)) {
+		t.FailNow()
+	}
+	assert.Equal(t, InfluxDBMetric{"system", "load1"}, config.InfluxDB.Metrics["node_load1"])
+
+	s := newTestServer(t, config)
+	w := s.get("/status")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Online. 1.00s latency.")
+	if recorded := queries.all(); assert.Len(t, recorded, 8) {
+		sort.Strings(recorded)
+		assert.Regexp(t, `^SELECT last\("load1"\) AS "node_load1", last\("load5"\) AS "node_load5", last\("load15"\) AS "node_load15" FROM "system" WHERE time >= \d+s AND time <= \d+s AND "host" = 'hive.example.com' GROUP BY \*$`, recorded[0])
+		assert.Regexp(t, `^SELECT last\("success"\) AS "probe_success", last\("duration"\) AS "probe_duration_seconds" FROM "blackbox" WHERE .* AND "server" = 'plex.example.com:32400' GROUP BY \*$`, recorded[1])
+		// The sparkline and the text of the load chart per range
+		assert.Regexp(t, `^SELECT mean\("duration"\) FROM "blackbox" WHERE .* AND "server" = 'plex.example.com:32400' GROUP BY time\(240s\), \* fill\(none\)$`, recorded[2])
+		for _, query := range recorded[3:] {
+			assert.Regexp(t, `^SELECT mean\("load1"\) FROM "system" WHERE .* AND "host" = 'hive.example.com' GROUP BY time\(\d+s\), \* fill\(none\)$`, query)
+		}
+	}
+
+	source := s.metricsSources[MetricsSourceInfluxDB]
+	samples, err := source.Range(context.Background(), MetricQuery{Metric: "ifHCOutOctets", Labels: map[string]string{"job": "snmp", "ifName": "eth'0"}, Rate: true},
+		time.Now().Add(-time.Hour), time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+	assert.Regexp(t, `^SELECT non_negative_derivative\(mean\("ifHCOutOctets"\), 1s\) FROM "interface" WHERE .* AND "ifName" = 'eth\\'0' GROUP BY time\(60s\), \* fill\(none\)$`, queries.all()[8])
+
+	// The CPUs are averaged, without their total
+	_, err = source.Range(context.Background(), MetricQuery{Metric: "node_cpu_seconds_total", Labels: map[string]string{"fqdn": "hive.example.com", "mode": "idle"}, Rate: true, Average: true},
+		time.Now().Add(-time.Hour), time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^SELECT non_negative_derivative\(mean\("time_idle"\), 1s\) FROM "cpu" WHERE .* AND "cpu" != 'cpu-total' AND "host" = 'hive.example.com' GROUP BY time\(60s\) fill\(none\)$`, queries.all()[9])
+
+	_, err = source.Instant(context.Background(), MetricQuery{Metric: "missing_metric"})
+	assert.Equal(t, ErrNoData, err)
+
+	// Several matching series are no answer, unless they are averaged
+	_, err = source.Instant(context.Background(), MetricQuery{Metric: "several_metric"})
+	assert.ErrorIs(t, err, ErrNoData)
+	assert.ErrorContains(t, err, "2 series of several_metric match")
+	_, err = source.Instants(context.Background(), []string{"several_metric"}, nil)
+	assert.ErrorIs(t, err, ErrNoData)
+	_, err = source.Instant(context.Background(), MetricQuery{Metric: "ifHCOutOctets", Average: true})
+	assert.NoError(t, err)
+	assert.Regexp(t, `^SELECT mean\("ifHCOutOctets"\) FROM "interface" WHERE time >= \d+s AND time <= \d+s$`, queries.all()[len(queries.all())-1])
+
+	_, err = source.Instant(context.Background(), MetricQuery{Metric: "unmapped_metric"})
+	assert.Error(t, err)
+}
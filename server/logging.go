@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LogOutputStderr   = "stderr"
+	LogOutputJournald = "journald"
+
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestID"
+	cacheResultKey  = "cacheResult"
+
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+// requestIDRegex matches the IDs accepted from proxies, like the $request_id
+// of nginx
+var requestIDRegex = regexp.MustCompile(`^[0-9A-Za-z._-]{1,64}$`)
+
+// newLogger returns a JSON logger writing to stderr, the journal or a file
+// rotated by size
+func newLogger(config Config) (*slog.Logger, error) {
+	switch config.LogOutput {
+	case "", LogOutputStderr:
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil
+	case LogOutputJournald:
+		conn, err := net.Dial("unixgram", journaldSocket)
+		if err != nil {
+			return nil, err
+		}
+		return slog.New(newJournaldHandler(conn)), nil
+	}
+
+	file, err := openRotatingFile(config.LogOutput, int64(config.LogMaxSize)*1024*1024, config.LogMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(slog.NewJSONHandler(file, nil)), nil
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// incomingRequestID returns the ID a trusted proxy tagged the request with,
+// empty if there is none or it isn't well-formed
+func incomingRequestID(c *gin.Context) string {
+	if _, trusted := c.RemoteIP(); !trusted {
+		return ""
+	}
+	id := c.GetHeader(requestIDHeader)
+	if !requestIDRegex.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// requestID returns the ID of the request of a context, empty outside of requests
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestLogger returns the logger with the ID of the request of a context
+func (s Server) requestLogger(c *gin.Context) *slog.Logger {
+	return s.logger.With("request_id", requestID(c))
+}
+
+// accessLogHandler tags every request with an ID and logs it once answered.
+// The ID of a trusted proxy is kept, so its log can be joined with this one.
+func (s Server) accessLogHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := incomingRequestID(c)
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Writer = requestIDWriter{c.Writer, id}
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "none"
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("query", c.Request.URL.RawQuery),
+			slog.String("route", route),
+			slog.Int("status", c.Writer.Status()),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.Float64("duration", time.Since(start).Seconds()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if cacheResult := c.GetString(cacheResultKey); cacheResult != "" {
+			attrs = append(attrs, slog.String("cache", cacheResult))
+		}
+		s.logger.LogAttrs(c.Request.Context(), slog.LevelInfo, "request", attrs...)
+	}
+}
+
+// requestIDWriter sets the ID of the request again before the headers are
+// sent, cached responses replay the headers of the request that filled the cache
+type requestIDWriter struct {
+	gin.ResponseWriter
+	id string
+}
+
+func (w requestIDWriter) WriteHeaderNow() {
+	w.setID()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w requestIDWriter) Write(data []byte) (int, error) {
+	w.setID()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w requestIDWriter) WriteString(data string) (int, error) {
+	w.setID()
+	return w.ResponseWriter.WriteString(data)
+}
+
+func (w requestIDWriter) setID() {
+	if !w.Written() {
+		w.Header().Set(requestIDHeader, w.id)
+	}
+}
+
+// rotatingFile is a log file that is renamed to path.1 once it exceeds
+// maxSize, shifting older files up to path.<maxBackups>
+type rotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if r.maxBackups > 0 {
+		for i := r.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(data []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// An empty file is never rotated, even if a single line exceeds the size
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+// journaldHandler sends every record as JSON in the MESSAGE field of a
+// journal entry, with the priority of its level
+type journaldHandler struct {
+	handler slog.Handler
+	// The JSON handler writes a record at once, the buffer is shared by all
+	// handlers derived with WithAttrs or WithGroup
+	mutex  *sync.Mutex
+	buffer *bytes.Buffer
+	conn   io.Writer
+}
+
+func newJournaldHandler(conn io.Writer) *journaldHandler {
+	buffer := new(bytes.Buffer)
+	return &journaldHandler{slog.NewJSONHandler(buffer, nil), new(sync.Mutex), buffer, conn}
+}
+
+func (j *journaldHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return j.handler.Enabled(ctx, level)
+}
+
+func (j *journaldHandler) Handle(ctx context.Context, record slog.Record) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.buffer.Reset()
+	if err := j.handler.Handle(ctx, record); err != nil {
+		return err
+	}
+
+	// See systemd-journald.socket, JSON never contains a newline
+	priority := 6
+	switch {
+	case record.Level >= slog.LevelError:
+		priority = 3
+	case record.Level >= slog.LevelWarn:
+		priority = 4
+	case record.Level < slog.LevelInfo:
+		priority = 7
+	}
+	_, err := fmt.Fprintf(j.conn, "PRIORITY=%d\nSYSLOG_IDENTIFIER=hashworksNET\nMESSAGE=%s\n", priority, strings.TrimSuffix(j.buffer.String(), "\n"))
+	return err
+}
+
+func (j *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &journaldHandler{j.handler.WithAttrs(attrs), j.mutex, j.buffer, j.conn}
+}
+
+func (j *journaldHandler) WithGroup(name string) slog.Handler {
+	return &journaldHandler{j.handler.WithGroup(name), j.mutex, j.buffer, j.conn}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	s := newTestServer(t, Config{AdminToken: "secret", LogOutput: path})
+
+	// The second response is cached, but has an ID of its own
+	var ids []string
+	for _, path := range []string{"/", "/", "/admin/announcements"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "192.0.2.1")
+		s.Router.ServeHTTP(w, req)
+		assert.Regexp(t, `^[0-9a-f]{16}$`, w.Header().Get("X-Request-ID"))
+		ids = append(ids, w.Header().Get("X-Request-ID"))
+
+		if path == "/admin/announcements" {
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Body.String(), `"request_id":"`+w.Header().Get("X-Request-ID")+`"`)
+		}
+	}
+	assert.NotEqual(t, ids[0], ids[1])
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !assert.Len(t, lines, 3) {
+		return
+	}
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	for i, entry := range entries {
+		assert.Equal(t, "request", entry["msg"])
+		assert.Equal(t, ids[i], entry["request_id"])
+		assert.Equal(t, "192.0.2.1", entry["client_ip"])
+	}
+	assert.Equal(t, "miss", entries[0]["cache"])
+	assert.Equal(t, "hit", entries[1]["cache"])
+	assert.Equal(t, float64(http.StatusUnauthorized), entries[2]["status"])
+	assert.Equal(t, "/admin/announcements", entries[2]["route"])
+}
+
+func TestRequestIDs(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	// IDs of trusted proxies are kept if well-formed
+	proxyID := "3f2a9c0d5e6b7a8190c1d2e3f4a5b6c7"
+	for _, test := range []struct {
+		name, remoteAddr, id string
+		kept                 bool
+	}{
+		{"trusted proxy", "127.0.0.1:1234", proxyID, true},
+		{"untrusted client", "192.0.2.1:1234", proxyID, false},
+		{"no ID", "127.0.0.1:1234", "", false},
+		{"spaces", "127.0.0.1:1234", "a b", false},
+		{"too long", "127.0.0.1:1234", strings.Repeat("a", 65), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/robots.txt", nil)
+			req.RemoteAddr = test.remoteAddr
+			req.Header.Set("X-Request-ID", test.id)
+			s.Router.ServeHTTP(w, req)
+			if test.kept {
+				assert.Equal(t, test.id, w.Header().Get("X-Request-ID"))
+			} else {
+				assert.Regexp(t, `^[0-9a-f]{16}$`, w.Header().Get("X-Request-ID"))
+			}
+		})
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	// Failed queries of a request are logged with its ID
+	path := filepath.Join(t.TempDir(), "error.log")
+	s := newTestServer(t, Config{
+		LogOutput:  path,
+		Prometheus: PrometheusConfig{Address: "http://127.0.0.1:1", Timeout: time.Second},
+		Nodes:      []NodeConfig{{Name: "hive", FQDN: "hive.example.com"}},
+	})
+
+	w := s.get("/chart/hive/load.json")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	id := w.Header().Get("X-Request-ID")
+	assert.Contains(t, w.Body.String(), `"request_id":"`+id+`"`)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Regexp(t, `"level":"ERROR","msg":".*connection refused","request_id":"`+id+`"`, string(data))
+}
+
+func TestLogOutputs(t *testing.T) {
+	t.Run("rotation", func(t *testing.T) {
+		// Every line goes to a file of its own, the oldest file is dropped
+		path := filepath.Join(t.TempDir(), "access.log")
+		file, err := openRotatingFile(path, 10, 2)
+		assert.NoError(t, err)
+		for _, line := range []string{"first\n", "second\n", "third\n"} {
+			_, err = file.Write([]byte(line))
+			assert.NoError(t, err)
+		}
+		for name, content := range map[string]string{path: "third\n", path + ".1": "second\n", path + ".2": "first\n"} {
+			data, err := os.ReadFile(name)
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(data), name)
+		}
+	})
+
+	t.Run("journald", func(t *testing.T) {
+		var journal bytes.Buffer
+		slog.New(newJournaldHandler(&journal)).With("request_id", "abc").Error("failed")
+		assert.Regexp(t, `^PRIORITY=3\nSYSLOG_IDENTIFIER=hashworksNET\nMESSAGE=\{"time":"[^"]+","level":"ERROR","msg":"failed","request_id":"abc"\}\n$`, journal.String())
+	})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+const (
+	MetricsSourcePrometheus = "prometheus"
+	MetricsSourceInfluxDB   = "influxdb"
+)
+
+// ErrNoData is returned by a MetricsSource if a query matched no series
+var ErrNoData = errors.New("no data")
+
+// MetricQuery describes a metric independent of the backend storing it.
+// Metric and label names follow the Prometheus exporters, backends like
+// InfluxDB translate them.
+type MetricQuery struct {
+	Metric string
+	Labels map[string]string
+	// Rate queries the per-second rate of a counter instead of its value
+	Rate bool
+	// Average combines all matching series by their mean, like the CPUs of a node
+	Average bool
+}
+
+// Sample is a single value of a metric
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// MetricsSource provides the data shown on the status page and the charts.
+// A query has to match exactly one series per metric, unless it averages
+// them. Otherwise ErrNoData is returned, see seriesError.
+type MetricsSource interface {
+	// Instant returns the current value of a query matching exactly one series
+	Instant(ctx context.Context, query MetricQuery) (float64, error)
+	// Instants returns the current values of several metrics with the same
+	// labels, keyed by metric name. Implementations should use a single query.
+	Instants(ctx context.Context, metrics []string, labels map[string]string) (map[string]float64, error)
+	// Range returns the values of a query matching exactly one series between
+	// start and end with a resolution of step
+	Range(ctx context.Context, query MetricQuery, start, end time.Time, step time.Duration) ([]Sample, error)
+}
+
+// seriesError is the error of a query matching count series of a metric
+// instead of one. Several series are no answer either, since the labels
+// don't tell which one is meant.
+func seriesError(metric string, count int) error {
+	if count == 0 {
+		return ErrNoData
+	}
+	return fmt.Errorf("%w: %d series of %s match", ErrNoData, count, metric)
+}
+
+func (s *Server) metricsSource(nodeConfig NodeConfig) MetricsSource {
+	if nodeConfig.Source == "" {
+		return s.metricsSources[MetricsSourcePrometheus]
+	}
+	return s.metricsSources[nodeConfig.Source]
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+// PrometheusConfig describes how to reach the Prometheus server.
+// Authentication and TLS use the same keys as a Prometheus scrape config,
+// e.g. basic_auth, bearer_token_file or tls_config.
+type PrometheusConfig struct {
+	Address string        `yaml:"address"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Label matchers added to every query, e.g. monitor="master"
+	Selector string `yaml:"selector"`
+
+	HTTPClientConfig config.HTTPClientConfig `yaml:",inline"`
+}
+
+// DefaultPrometheusConfig returns the settings used for a local Prometheus
+func DefaultPrometheusConfig() PrometheusConfig {
+	return PrometheusConfig{
+		Address:  "http://127.0.0.1:9090",
+		Timeout:  10 * time.Second,
+		Selector: `monitor="master"`,
+	}
+}
+
+func (c *PrometheusConfig) validate() error {
+	if c.Address == "" {
+		c.Address = DefaultPrometheusConfig().Address
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultPrometheusConfig().Timeout
+	}
+	return c.HTTPClientConfig.Validate()
+}
+
+// prometheusSource queries a Prometheus server using PromQL
+type prometheusSource struct {
+	api    v1.API
+	config PrometheusConfig
+	logger *slog.Logger
+}
+
+func newPrometheusSource(prometheusConfig PrometheusConfig, logger *slog.Logger) (*prometheusSource, error) {
+	roundTripper, err := config.NewRoundTripperFromConfig(prometheusConfig.HTTPClientConfig, "prometheus", false)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := api.NewClient(api.Config{
+		Address:      prometheusConfig.Address,
+		RoundTripper: roundTripper,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &prometheusSource{v1.NewAPI(client), prometheusConfig, logger}, nil
+}
+
+// matchers returns the PromQL label matchers of the given labels and the
+// configured selector
+func (p *prometheusSource) matchers(labels map[string]string) []string {
+	var matchers []string
+	for _, name := range sortedKeys(labels) {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	if p.config.Selector != "" {
+		matchers = append(matchers, p.config.Selector)
+	}
+	return matchers
+}
+
+func (p *prometheusSource) selector(metric string, labels map[string]string) string {
+	return metric + "{" + strings.Join(p.matchers(labels), ",") + "}"
+}
+
+func (p *prometheusSource) promQL(query MetricQuery) string {
+	promQL := p.selector(query.Metric, query.Labels)
+	if query.Rate {
+		promQL = "irate(" + promQL + "[5m])"
+	}
+	if query.Average {
+		promQL = "avg(" + promQL + ")"
+	}
+	return promQL
+}
+
+func (p *prometheusSource) query(ctx context.Context, promQL string) (model.Vector, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	result, warnings, err := p.api.Query(ctx, promQL, time.Now())
+	if len(warnings) > 0 {
+		p.logger.Warn("Prometheus warnings", "warnings", warnings)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vector, ok := result.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type %T", result)
+	}
+
+	return vector, nil
+}
+
+func (p *prometheusSource) Instant(ctx context.Context, query MetricQuery) (float64, error) {
+	vector, err := p.query(ctx, p.promQL(query))
+	if err != nil {
+		return 0, err
+	}
+	if vector.Len() != 1 {
+		return 0, seriesError(query.Metric, vector.Len())
+	}
+
+	return float64(vector[0].Value), nil
+}
+
+// Instants queries all metrics at once by matching their name with a regular expression
+func (p *prometheusSource) Instants(ctx context.Context, metrics []string, labels map[string]string) (map[string]float64, error) {
+	names := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		names = append(names, regexp.QuoteMeta(metric))
+	}
+	matchers := append([]string{fmt.Sprintf("%s=~%q", model.MetricNameLabel, strings.Join(names, "|"))}, p.matchers(labels)...)
+
+	vector, err := p.query(ctx, "{"+strings.Join(matchers, ",")+"}")
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(metrics))
+	values := make(map[string]float64, len(metrics))
+	for _, sample := range vector {
+		name := string(sample.Metric[model.MetricNameLabel])
+		counts[name]++
+		values[name] = float64(sample.Value)
+	}
+	for _, metric := range metrics {
+		if counts[metric] != 1 {
+			return nil, seriesError(metric, counts[metric])
+		}
+	}
+
+	return values, nil
+}
+
+func (p *prometheusSource) Range(ctx context.Context, query MetricQuery, start, end time.Time, step time.Duration) ([]Sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	result, warnings, err := p.api.QueryRange(ctx, p.promQL(query), v1.Range{
+		Start: start,
+		End:   end,
+		Step:  step,
+	})
+	if len(warnings) > 0 {
+		p.logger.Warn("Prometheus warnings", "warnings", warnings)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	matrix, ok := result.(model.Matrix)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type %T", result)
+	}
+	if matrix.Len() != 1 {
+		return nil, seriesError(query.Metric, matrix.Len())
+	}
+
+	samples := make([]Sample, 0, len(matrix[0].Values))
+	for _, samplePair := range matrix[0].Values {
+		samples = append(samples, Sample{samplePair.Timestamp.Time(), float64(samplePair.Value)})
+	}
+
+	return samples, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	promConfig "github.com/prometheus/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// queryRecorder collects the queries received by a test server
+type queryRecorder struct {
+	sync.Mutex
+	queries []string
+}
+
+func (r *queryRecorder) record(query string) {
+	r.Lock()
+	defer r.Unlock()
+	r.queries = append(r.queries, query)
+}
+
+func (r *queryRecorder) all() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string{}, r.queries...)
+}
+
+var prometheusNameRegex = regexp.MustCompile(`^\{__name__=~"([^"]+)"`)
+var prometheusMetricRegex = regexp.MustCompile(`([a-zA-Z_:][a-zA-Z0-9_:]*)\{`)
+
+// newPrometheusTestServer starts a TLS server answering instant and range
+// queries with the value of the given function per metric, metrics without a
+// value are omitted. Comma separated values of instant queries are answered
+// as several series. Requests without the basic auth test:secret are rejected.
+func newPrometheusTestServer(t *testing.T, value func(metric string) string) (*httptest.Server, string, *queryRecorder) {
+	recorder := &queryRecorder{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "test" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/query" && r.URL.Path != "/api/v1/query_range" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query := r.FormValue("query")
+		recorder.record(query)
+
+		var metrics []string
+		if match := prometheusNameRegex.FindStringSubmatch(query); match != nil {
+			metrics = strings.Split(match[1], "|")
+		} else {
+			metrics = []string{prometheusMetricRegex.FindStringSubmatch(query)[1]}
+		}
+
+		if r.URL.Path == "/api/v1/query_range" {
+			start, _ := strconv.ParseFloat(r.FormValue("start"), 64)
+			end, _ := strconv.ParseFloat(r.FormValue("end"), 64)
+			step, _ := strconv.ParseFloat(r.FormValue("step"), 64)
+			var results []string
+			if value(metrics[0]) != "" {
+				var values []string
+				for timestamp := start; timestamp <= end; timestamp += step {
+					values = append(values, fmt.Sprintf(`[%f,"%s"]`, timestamp, value(metrics[0])))
+				}
+				results = append(results, fmt.Sprintf(`{"metric":{"__name__":"%s"},"values":[%s]}`, metrics[0], strings.Join(values, ",")))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%s]}}`, strings.Join(results, ","))
+			return
+		}
+
+		var results []string
+		for _, metric := range metrics {
+			if value(metric) == "" {
+				continue
+			}
+			for instance, value := range strings.Split(value(metric), ",") {
+				results = append(results, fmt.Sprintf(`{"metric":{"__name__":"%s","instance":"%d"},"value":[%d,"%s"]}`, metric, instance, time.Now().Unix(), value))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, strings.Join(results, ","))
+	}))
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	assert.NoError(t, err)
+
+	return server, caFile, recorder
+}
+
+// newPrometheusTestConfig starts a Prometheus test server like
+// newPrometheusTestServer and returns a configuration querying it
+func newPrometheusTestConfig(t *testing.T, value func(metric string) string) (PrometheusConfig, *queryRecorder) {
+	server, caFile, recorder := newPrometheusTestServer(t, value)
+
+	config := DefaultPrometheusConfig()
+	config.Address = server.URL
+	config.HTTPClientConfig.BasicAuth = &promConfig.BasicAuth{Username: "test", Password: "secret"}
+	config.HTTPClientConfig.TLSConfig.CAFile = caFile
+	return config, recorder
+}
+
+func TestPrometheusConfig(t *testing.T) {
+	prometheus, caFile, queries := newPrometheusTestServer(t, func(metric string) string {
+		return "1"
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(path, []byte(`prometheus:
+  address: `+prometheus.URL+`
+  timeout: 2s
+  basic_auth:
+    username: test
+    password: secret
+  tls_config:
+    ca_file: `+caFile+`
+nodes:
+  - name: hive
+    fqdn: hive.example.com
+    services:
+      - name: Plex
+        type: probe
+        instance: plex.example.com:32400
+`), 0600)
+	assert.NoError(t, err)
+
+	config := Config{Debug: true, Prometheus: DefaultPrometheusConfig()}
+	if !assert.NoError(t, config.LoadFile(path)) {
+		t.FailNow()
+	}
+	assert.Equal(t, 2*time.Second, config.Prometheus.Timeout)
+	assert.Equal(t, `monitor="master"`, config.Prometheus.Selector)
+
+	s := newTestServer(t, config)
+	w := s.get("/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Online. 1.00s latency.")
+	// One query for the loads and one per service, the text of the load chart
+	// per range and the sparkline of every service
+	assert.ElementsMatch(t, []string{
+		`{__name__=~"node_load1|node_load5|node_load15",fqdn="hive.example.com",monitor="master"}`,
+		`{__name__=~"probe_success|probe_duration_seconds",instance="plex.example.com:32400",monitor="master"}`,
+		`node_load1{fqdn="hive.example.com",monitor="master"}`,
+		`node_load1{fqdn="hive.example.com",monitor="master"}`,
+		`node_load1{fqdn="hive.example.com",monitor="master"}`,
+		`node_load1{fqdn="hive.example.com",monitor="master"}`,
+		`node_load1{fqdn="hive.example.com",monitor="master"}`,
+		`probe_duration_seconds{instance="plex.example.com:32400",monitor="master"}`,
+	}, queries.all())
+
+	// Without the CA the self-signed certificate is rejected
+	config.Prometheus.HTTPClientConfig.TLSConfig.CAFile = ""
+	s = newTestServer(t, config)
+	w = s.get("/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Status-Degraded"))
+	assert.Contains(t, w.Body.String(), "certificate")
+}
+
+func TestPrometheusSeveralSeries(t *testing.T) {
+	prometheusConfig, _ := newPrometheusTestConfig(t, func(metric string) string {
+		switch metric {
+		case "node_load1":
+			return "1"
+		case "node_load5":
+			return "1,2"
+		default:
+			return ""
+		}
+	})
+	source, err := newPrometheusSource(prometheusConfig, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	_, err = source.Instant(context.Background(), MetricQuery{Metric: "node_load1"})
+	assert.NoError(t, err)
+
+	_, err = source.Instant(context.Background(), MetricQuery{Metric: "node_load5"})
+	assert.ErrorIs(t, err, ErrNoData)
+	assert.ErrorContains(t, err, "2 series of node_load5 match")
+
+	_, err = source.Instants(context.Background(), []string{"node_load1", "node_load5"}, nil)
+	assert.ErrorIs(t, err, ErrNoData)
+	assert.ErrorContains(t, err, "2 series of node_load5 match")
+
+	_, err = source.Instants(context.Background(), []string{"node_load1", "missing"}, nil)
+	assert.Equal(t, ErrNoData, err)
+}
@@ -30,7 +30,7 @@ func (s Server) getSecureOptions() secure.Options {
 		STSSeconds:           315360000,
 		STSIncludeSubdomains: true,
 		STSPreload:           true,
-		ForceSTSHeader:       s.config.TLSProxy,
+		ForceSTSHeader:       s.config.https(),
 		FrameDeny:            true,
 		ContentTypeNosniff:   true,
 		BrowserXssFilter:     true,
@@ -61,6 +61,11 @@ func (s Server) getSecureOptions() secure.Options {
 	return options
 }
 
+// https is true if visitors connect with TLS, to this server or the proxy
+func (c Config) https() bool {
+	return c.TLSProxy || c.TLSCert != ""
+}
+
 func (s Server) getCSP(safeCSS bool) string {
 	var styleSrc string
 	if safeCSS {
@@ -69,7 +74,7 @@ func (s Server) getCSP(safeCSS bool) string {
 		styleSrc = "'unsafe-inline'"
 	}
 	upgradeInSecureRequests := ""
-	if s.config.TLSProxy {
+	if s.config.https() {
 		upgradeInSecureRequests = "upgrade-insecure-requests; "
 	}
 	return fmt.Sprintf("%s"+
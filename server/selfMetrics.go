@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"github.com/go-errors/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// selfMetrics are the metrics this server exposes about itself at /metrics.
+// Every server has its own registry, so tests can create several of them.
+type selfMetrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	cacheRequests   *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+	renderDuration  *prometheus.HistogramVec
+}
+
+func newSelfMetrics(config Config) *selfMetrics {
+	m := &selfMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "hashworksnet_http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "hashworksnet_http_request_duration_seconds",
+			Help: "Duration of HTTP requests by route and method.",
+		}, []string{"route", "method"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "hashworksnet_cache_requests_total",
+			Help: "Number of lookups in the in-memory cache by kind of entry and result.",
+		}, []string{"cache", "result"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "hashworksnet_upstream_query_duration_seconds",
+			Help: "Duration of queries of the metrics sources by source and type of query.",
+		}, []string{"source", "type"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "hashworksnet_upstream_query_errors_total",
+			Help: "Number of failed queries of the metrics sources by source and type of query.",
+		}, []string{"source", "type"}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "hashworksnet_template_render_duration_seconds",
+			Help:    "Duration of rendering HTML templates by template.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"template"}),
+	}
+
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hashworksnet_build_info",
+		Help: "Always 1, labeled by the version and build date of this server.",
+	}, []string{"version", "build_date", "goversion"})
+	buildInfo.WithLabelValues(config.Version, config.BuildDate, runtime.Version()).Set(1)
+
+	m.registry.MustRegister(
+		m.requests, m.requestDuration, m.cacheRequests,
+		m.queryDuration, m.queryErrors, m.renderDuration, buildInfo,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *selfMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// requestHandler counts the requests by their route pattern, paths would
+// create a series per 404
+func (m *selfMetrics) requestHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "none"
+		}
+		m.requests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		m.requestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *selfMetrics) cacheRequest(kind string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheRequests.WithLabelValues(kind, result).Inc()
+}
+
+// cacheGet looks up a value stored with cacheSet and counts the hit or miss
+func (s Server) cacheGet(kind, key string, value interface{}) bool {
+	hit := s.store.Get(kind+":"+key, value) == nil
+	s.metrics.cacheRequest(kind, hit)
+	return hit
+}
+
+func (s Server) cacheSet(kind, key string, value interface{}, expire time.Duration) {
+	_ = s.store.Set(kind+":"+key, value, expire)
+}
+
+// metricsSource measures the queries of a MetricsSource
+type metricsSource struct {
+	MetricsSource
+	name    string
+	metrics *selfMetrics
+}
+
+func (m metricsSource) observe(queryType string, start time.Time, err error) {
+	m.metrics.queryDuration.WithLabelValues(m.name, queryType).Observe(time.Since(start).Seconds())
+	// Missing data is an answer, not a failure of the source
+	if err != nil && !errors.Is(err, ErrNoData) {
+		m.metrics.queryErrors.WithLabelValues(m.name, queryType).Inc()
+	}
+}
+
+func (m metricsSource) Instant(ctx context.Context, query MetricQuery) (float64, error) {
+	start := time.Now()
+	value, err := m.MetricsSource.Instant(ctx, query)
+	m.observe("instant", start, err)
+	return value, err
+}
+
+func (m metricsSource) Instants(ctx context.Context, metrics []string, labels map[string]string) (map[string]float64, error) {
+	start := time.Now()
+	values, err := m.MetricsSource.Instants(ctx, metrics, labels)
+	m.observe("instant", start, err)
+	return values, err
+}
+
+func (m metricsSource) Range(ctx context.Context, query MetricQuery, start, end time.Time, step time.Duration) ([]Sample, error) {
+	queryStart := time.Now()
+	samples, err := m.MetricsSource.Range(ctx, query, start, end, step)
+	m.observe("range", queryStart, err)
+	return samples, err
+}
+
+// metricsHTMLRender measures the rendering of every template
+type metricsHTMLRender struct {
+	render.HTMLRender
+	metrics *selfMetrics
+}
+
+func (m metricsHTMLRender) Instance(name string, data interface{}) render.Render {
+	return metricsRender{m.HTMLRender.Instance(name, data), m.metrics.renderDuration.WithLabelValues(name)}
+}
+
+type metricsRender struct {
+	render   render.Render
+	duration prometheus.Observer
+}
+
+func (m metricsRender) Render(w http.ResponseWriter) error {
+	start := time.Now()
+	err := m.render.Render(w)
+	m.duration.Observe(time.Since(start).Seconds())
+	return err
+}
+
+func (m metricsRender) WriteContentType(w http.ResponseWriter) {
+	m.render.WriteContentType(w)
+}
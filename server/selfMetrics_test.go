@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfMetrics(t *testing.T) {
+	t.Run("router", func(t *testing.T) {
+		s := newTestServer(t, Config{
+			Version:    "v1.2.3",
+			BuildDate:  "2022-03-21",
+			Prometheus: PrometheusConfig{Address: "http://127.0.0.1:1", Timeout: time.Second},
+		})
+
+		// The second request is answered by the cache
+		for _, path := range []string{"/", "/", "/not-existing-sub-page"} {
+			s.get(path)
+		}
+		_, err := s.metricsSource(NodeConfig{}).Instant(context.Background(), MetricQuery{Metric: "node_load1"})
+		assert.Error(t, err)
+		// Missing data is no error, even if wrapped
+		s.metricsSources[MetricsSourcePrometheus].(metricsSource).observe("range", time.Now(), fmt.Errorf("query failed: %w", ErrNoData))
+
+		w := s.get("/metrics")
+		assert.Equal(t, http.StatusOK, w.Code)
+		for _, metric := range []string{
+			`hashworksnet_http_requests_total{code="200",method="GET",route="/"} 2`,
+			`hashworksnet_http_requests_total{code="404",method="GET",route="none"} 1`,
+			`hashworksnet_http_request_duration_seconds_count{method="GET",route="/"} 2`,
+			`hashworksnet_cache_requests_total{cache="page",result="hit"} 1`,
+			`hashworksnet_cache_requests_total{cache="page",result="miss"} 2`,
+			`hashworksnet_upstream_query_duration_seconds_count{source="prometheus",type="instant"} 1`,
+			`hashworksnet_upstream_query_errors_total{source="prometheus",type="instant"} 1`,
+			`hashworksnet_template_render_duration_seconds_count{template="index"} 1`,
+			`hashworksnet_build_info{build_date="2022-03-21",goversion="` + runtime.Version() + `",version="v1.2.3"} 1`,
+		} {
+			assert.Contains(t, w.Body.String(), metric)
+		}
+		assert.NotContains(t, w.Body.String(), `hashworksnet_upstream_query_errors_total{source="prometheus",type="range"}`)
+	})
+
+	t.Run("own listener", func(t *testing.T) {
+		s := newTestServer(t, Config{MetricsAddress: "127.0.0.1:9100"})
+		assert.Equal(t, http.StatusNotFound, s.get("/metrics").Code)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/metrics", nil)
+		s.MetricsHandler().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "hashworksnet_build_info")
+	})
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+const (
+	// Names of the listeners passed on SIGHUP, starting at file descriptor 3,
+	// followed by a pipe the new process writes to once ready
+	inheritedListenersEnv = "HWNET_INHERITED_LISTENERS"
+	readyFDEnv            = "HWNET_READY_FD"
+
+	// Names of the listeners, like the FileDescriptorName of a systemd socket
+	listenerHTTP     = "http"
+	listenerMetrics  = "metrics"
+	listenerRedirect = "redirect"
+
+	// Addresses with this prefix are paths of unix sockets
+	unixAddressPrefix = "unix:"
+	// Stands in for the peers of unix sockets, no TCP peer has it
+	unixPeerIP = "0.0.0.0"
+
+	// How long a new process may take to take over the listeners
+	handoffTimeout = time.Minute
+)
+
+type namedListener struct {
+	name     string
+	address  string
+	listener net.Listener
+	handler  http.Handler
+	// Serves HTTPS if set
+	tlsConfig *tls.Config
+}
+
+// ListenAndServe serves the router on address and the metrics on the
+// MetricsAddress, if set. Both may be unix sockets, see listen. With a
+// TLSCert the router is served with HTTPS, and HTTP requests to the
+// TLSRedirectAddress are redirected to it. Sockets passed by systemd are
+// used instead, named http, metrics and redirect. SIGTERM and SIGINT stop accepting connections and wait
+// up to the ShutdownTimeout for running requests. SIGHUP starts the
+// executable again with the same arguments, passes the listeners on and
+// exits once the new process serves them.
+func (s Server) ListenAndServe(address string) error {
+	inherited, readyFile, err := inheritedListeners()
+	if err != nil {
+		return err
+	}
+	if inherited == nil {
+		inherited, err = systemdListeners()
+		if err != nil {
+			return err
+		}
+	}
+
+	listeners := []namedListener{{name: listenerHTTP, address: address, handler: s.Router}}
+	if s.config.MetricsAddress != "" {
+		listeners = append(listeners, namedListener{name: listenerMetrics, address: s.config.MetricsAddress, handler: s.MetricsHandler()})
+	}
+	if s.config.TLSCert != "" {
+		certificates, err := newCertificateStore(s.config)
+		if err != nil {
+			return err
+		}
+		listeners[0].tlsConfig = tlsConfig(certificates)
+
+		// Like a renewal hook would after replacing the files
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGUSR1)
+		defer signal.Stop(reload)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.watchCertificate(ctx, certificates, reload)
+
+		if s.config.TLSRedirectAddress != "" {
+			// The handler needs the port of the HTTPS listener, see below
+			listeners = append(listeners, namedListener{name: listenerRedirect, address: s.config.TLSRedirectAddress})
+		}
+	}
+	for i := range listeners {
+		if listener, ok := inherited[listeners[i].name]; ok {
+			listeners[i].listener = listener
+			delete(inherited, listeners[i].name)
+			continue
+		}
+		listeners[i].listener, err = s.listen(listeners[i].address)
+		if err != nil {
+			return err
+		}
+	}
+	// The configuration changed since the last process
+	for _, listener := range inherited {
+		_ = listener.Close()
+	}
+	for i := range listeners {
+		if listeners[i].name == listenerRedirect {
+			listeners[i].handler = s.redirectHandler(listeners[0].listener.Addr())
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	// Templates and CSS are loaded by NewServer, the listeners accept now
+	ready := "READY=1"
+	if readyFile != nil {
+		// The previous process drains its requests once this is written
+		_, _ = readyFile.Write([]byte("ready"))
+		_ = readyFile.Close()
+		ready = "MAINPID=" + strconv.Itoa(os.Getpid()) + "\n" + ready
+	}
+	if err := sdNotify(ready); err != nil {
+		s.logger.Error(err.Error())
+	}
+
+	if interval := watchdogInterval(); interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.watchdog(ctx, interval)
+	}
+
+	return s.serve(listeners, signals)
+}
+
+// serve answers requests of the listeners until SIGTERM or SIGINT arrives or
+// they were passed on, see wait
+func (s Server) serve(listeners []namedListener, signals <-chan os.Signal) error {
+	errs := make(chan error, len(listeners))
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, l := range listeners {
+		handler := l.handler
+		if l.listener.Addr().Network() == "unix" {
+			handler = trustUnixPeers(handler)
+		}
+		server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second, TLSConfig: l.tlsConfig}
+		servers = append(servers, server)
+		go func(l namedListener) {
+			var err error
+			if l.tlsConfig != nil {
+				// The certificate comes from GetCertificate
+				err = server.ServeTLS(l.listener, "", "")
+			} else {
+				err = server.Serve(l.listener)
+			}
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}(l)
+	}
+
+	handedOff, err := s.wait(listeners, errs, signals)
+	s.shutdown(servers)
+	// handoff closed it already
+	if !handedOff {
+		if closeErr := s.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// wait returns once a server failed, SIGTERM or SIGINT arrived or the
+// listeners were passed on after SIGHUP. If passing them on fails, this
+// process keeps serving them.
+func (s Server) wait(listeners []namedListener, errs <-chan error, signals <-chan os.Signal) (handedOff bool, err error) {
+	for {
+		select {
+		case err := <-errs:
+			return false, err
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				s.logger.Info("shutting down", "signal", sig.String())
+				_ = sdNotify("STOPPING=1")
+				return false, nil
+			}
+
+			s.logger.Info("passing listeners on", "signal", sig.String())
+			_ = sdNotify("RELOADING=1")
+			err := s.handoff(listeners)
+			if err == nil {
+				return true, nil
+			}
+			// Like a broken deploy, which mustn't take the site down
+			s.logger.Error("passing listeners on failed: " + err.Error())
+			if err := s.reopen(); err != nil {
+				s.logger.Error(err.Error())
+			}
+			_ = sdNotify("READY=1")
+		}
+	}
+}
+
+// shutdown stops accepting connections and waits for running requests, until
+// the ShutdownTimeout closes the remaining connections
+func (s Server) shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if err := server.Shutdown(ctx); err != nil {
+				s.logger.Error(err.Error())
+				_ = server.Close()
+			}
+			done <- struct{}{}
+		}(server)
+	}
+	for range servers {
+		<-done
+	}
+}
+
+// osExecutable is the program started by handoff, replaced in tests
+var osExecutable = os.Executable
+
+// handoff starts a new process with the listeners and waits until it is
+// ready. The status collection stops first, since the history can only be
+// opened by one process. wait starts it again if the handoff fails.
+func (s Server) handoff(listeners []namedListener) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	executable, err := osExecutable()
+	if err != nil {
+		return err
+	}
+
+	// The descriptors are passed as they are. exec.Cmd would switch the shared
+	// sockets to blocking mode, an accept would then block the shutdown.
+	names := make([]string, 0, len(listeners))
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, l := range listeners {
+		conn, ok := l.listener.(syscall.Conn)
+		if !ok {
+			return errors.Errorf("listener of %s can't be passed on", l.address)
+		}
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		if err := raw.Control(func(fd uintptr) { fds = append(fds, fd) }); err != nil {
+			return err
+		}
+		names = append(names, l.name)
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	// The new process pings the watchdog once it is the main process
+	var env []string
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "WATCHDOG_PID=") {
+			env = append(env, variable)
+		}
+	}
+
+	pid, err := syscall.ForkExec(executable, append([]string{executable}, os.Args[1:]...), &syscall.ProcAttr{
+		Env: append(env,
+			inheritedListenersEnv+"="+strings.Join(names, ","),
+			readyFDEnv+"="+strconv.Itoa(listenFDsStart+len(names)),
+		),
+		Files: append(fds, readyWriter.Fd()),
+	})
+	_ = readyWriter.Close()
+	if err != nil {
+		return err
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("passed listeners on", "pid", pid)
+
+	// The pipe is closed once the new process is ready or exited, only a
+	// ready one writes to it
+	_ = ready.SetReadDeadline(time.Now().Add(handoffTimeout))
+	n, err := io.Copy(io.Discard, ready)
+	if err != nil {
+		_ = process.Kill()
+		_, _ = process.Wait()
+		return errors.Errorf("new process didn't get ready: %s", err)
+	}
+	if n == 0 {
+		state, err := process.Wait()
+		if err != nil {
+			return errors.Errorf("new process exited before getting ready: %s", err)
+		}
+		return errors.Errorf("new process exited before getting ready: %s", state)
+	}
+
+	// The new process listens on the same paths
+	for _, l := range listeners {
+		if unixListener, ok := l.listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
+
+// listen listens on a TCP address or on the path of a unix socket prefixed
+// with unix:, which is replaced if it exists and gets the SocketMode and
+// SocketGroup
+func (s Server) listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixAddressPrefix) {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, unixAddressPrefix)
+
+	// Left behind by a process that didn't exit cleanly
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, _ := strconv.ParseUint(s.config.SocketMode, 8, 32)
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if s.config.SocketGroup != "" {
+		gid, err := strconv.Atoi(s.config.SocketGroup)
+		if err != nil {
+			group, err := user.LookupGroup(s.config.SocketGroup)
+			if err != nil {
+				_ = listener.Close()
+				return nil, err
+			}
+			gid, _ = strconv.Atoi(group.Gid)
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// trustUnixPeers gives requests of unix sockets an address of a trusted
+// proxy, they have none to resolve the client IP of X-Forwarded-For with
+func trustUnixPeers(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = net.JoinHostPort(unixPeerIP, "0")
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// inheritedListeners returns the listeners passed on by handoff, keyed by
+// their name, and the pipe to write to once ready
+func inheritedListeners() (map[string]net.Listener, *os.File, error) {
+	names := os.Getenv(inheritedListenersEnv)
+	if names == "" {
+		return nil, nil, nil
+	}
+	readyFD, err := strconv.Atoi(os.Getenv(readyFDEnv))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Not passed on to processes started by this one
+	_ = os.Unsetenv(inheritedListenersEnv)
+	_ = os.Unsetenv(readyFDEnv)
+
+	var files []*os.File
+	for i, name := range strings.Split(names, ",") {
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), name))
+	}
+	listeners, err := listenersFromFiles(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	return listeners, os.NewFile(uintptr(readyFD), "ready"), nil
+}
+
+// listenersFromFiles creates listeners of the sockets, keyed by the names of
+// the files
+func listenersFromFiles(files []*os.File) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener, len(files))
+	for _, file := range files {
+		listener, err := net.FileListener(file)
+		// The listener uses a duplicate of the file descriptor
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners[file.Name()] = listener
+	}
+	return listeners, nil
+}
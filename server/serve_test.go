@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// startServe runs serve in the background, it stops once the returned
+// channel receives SIGTERM and sends its result to the other one
+func startServe(s Server, listeners []namedListener) (chan<- os.Signal, <-chan error) {
+	signals := make(chan os.Signal, 1)
+	served := make(chan error)
+	go func() {
+		served <- s.serve(listeners, signals)
+	}()
+	return signals, served
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	s := newTestServer(t, Config{ShutdownTimeout: 5 * time.Second})
+
+	started := make(chan struct{})
+	s.Router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		c.String(http.StatusOK, "finished")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+
+	// Listeners passed on by a previous process are found by their name
+	file, err := listener.(*net.TCPListener).File()
+	assert.NoError(t, err)
+	fd, err := syscall.Dup(int(file.Fd()))
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	assert.NoError(t, listener.Close())
+	listeners, err := listenersFromFiles([]*os.File{os.NewFile(uintptr(fd), listenerHTTP)})
+	assert.NoError(t, err)
+	if !assert.Contains(t, listeners, listenerHTTP) {
+		return
+	}
+
+	signals, served := startServe(s, []namedListener{{name: listenerHTTP, address: address, listener: listeners[listenerHTTP], handler: s.Router}})
+
+	response := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + address + "/slow")
+		if !assert.NoError(t, err) {
+			response <- ""
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		response <- string(body)
+	}()
+
+	// The running request finishes, new connections are refused
+	<-started
+	signals <- syscall.SIGTERM
+	assert.Equal(t, "finished", <-response)
+	assert.NoError(t, <-served)
+	_, err = net.Dial("tcp", address)
+	assert.Error(t, err)
+}
+
+func TestFailedHandoff(t *testing.T) {
+	osExecutable = func() (string, error) {
+		return exec.LookPath("false")
+	}
+	defer func() { osExecutable = os.Executable }()
+
+	prometheus, _ := newPrometheusTestConfig(t, func(metric string) string {
+		return "1"
+	})
+	logPath := filepath.Join(t.TempDir(), "server.log")
+	s := newTestServer(t, Config{
+		StateDir:       t.TempDir(),
+		StatusInterval: 50 * time.Millisecond,
+		LogOutput:      logPath,
+		Prometheus:     prometheus,
+		Nodes: []NodeConfig{{
+			Name:     "hive",
+			FQDN:     "hive.example.com",
+			Services: []ServiceConfig{{Name: "Plex", Type: ServiceTypeProbe, Instance: "plex.example.com:32400"}},
+		}},
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	signals, served := startServe(s, []namedListener{{name: listenerHTTP, address: address, listener: listener, handler: s.Router}})
+
+	signals <- syscall.SIGHUP
+	var failed time.Time
+	assert.Eventually(t, func() bool {
+		data, _ := os.ReadFile(logPath)
+		failed = time.Now()
+		return strings.Contains(string(data), "passing listeners on failed: new process exited before getting ready: exit status 1")
+	}, time.Second, 10*time.Millisecond)
+
+	// The status is collected and recorded again
+	assert.Eventually(t, func() bool {
+		snapshot, _ := s.statusSnapshot(context.Background())
+		return snapshot.Time.After(failed)
+	}, time.Second, 10*time.Millisecond)
+	resp, err := http.Get("http://" + address + "/status")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, string(body), "<span>24h: 100.00%</span>")
+	}
+
+	// The listener still accepts, so it closes on shutdown
+	signals <- syscall.SIGTERM
+	assert.NoError(t, <-served)
+}
+
+func TestUnixSocket(t *testing.T) {
+	s := newTestServer(t, Config{SocketMode: "0600", SocketGroup: strconv.Itoa(os.Getgid())})
+	s.Router.GET("/ip", func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP())
+	})
+
+	// A socket left behind is replaced
+	path := filepath.Join(t.TempDir(), "http.sock")
+	stale, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, stale.Close())
+
+	listener, err := s.listen("unix:" + path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSocket|0600, info.Mode())
+
+	signals, served := startServe(s, []namedListener{{name: listenerHTTP, address: "unix:" + path, listener: listener, handler: s.Router}})
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	// The proxy on the other end of the socket is trusted
+	req, _ := http.NewRequest("GET", "http://localhost/ip", nil)
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	resp, err := client.Do(req)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "192.0.2.1", string(body))
+	}
+
+	signals <- syscall.SIGTERM
+	assert.NoError(t, <-served)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInvalidSocketMode(t *testing.T) {
+	_, err := NewServer(testConfig(Config{SocketMode: "rw"}))
+	assert.EqualError(t, err, "invalid socket mode 'rw'")
+}
@@ -2,10 +2,9 @@ package server
 
 import (
 	"crypto/sha256"
-	"embed"
 	"encoding/base64"
-	"fmt"
 	"io/fs"
+	"log/slog"
 	"time"
 
 	_ "embed"
@@ -29,43 +28,105 @@ type Server struct {
 	cssSha256 []string
 	config    Config
 	startTime time.Time
+	logger    *slog.Logger
+
+	metricsSources map[string]MetricsSource
+	collector      *statusCollector
+	history        *history
+	announcements  *announcements
+	metrics        *selfMetrics
 }
 
 type Config struct {
-	Version       string
-	BuildDate     string
-	GinMode       string
-	TLSProxy      bool
-	GZIPExtension bool
-	Debug         bool
-	Domain        string
-	TrustedProxy  string
-	StaticContent embed.FS
+	Version       string `yaml:"-"`
+	BuildDate     string `yaml:"-"`
+	GinMode       string `yaml:"-"`
+	TLSProxy      bool   `yaml:"-"`
+	GZIPExtension bool   `yaml:"-"`
+	Debug         bool   `yaml:"-"`
+	Domain        string `yaml:"-"`
+	TrustedProxy  string `yaml:"-"`
+	StateDir      string `yaml:"-"`
+	AdminToken    string `yaml:"-"`
+	// Serves /metrics on its own listener instead of the router if set
+	MetricsAddress string `yaml:"-"`
+	// Serves HTTPS instead of HTTP if set, see certificateStore. The
+	// configuration file may set them as well.
+	TLSCert       string `yaml:"tls_cert"`
+	TLSKey        string `yaml:"tls_key"`
+	TLSOCSPStaple string `yaml:"tls_ocsp_staple"`
+	// Redirects HTTP requests to HTTPS, if set
+	TLSRedirectAddress string `yaml:"tls_redirect_address"`
+	// Octal permissions and group of unix sockets, like 0660 and http
+	SocketMode  string `yaml:"-"`
+	SocketGroup string `yaml:"-"`
+	// stderr, journald or the path of a file rotated after LogMaxSize MiB
+	LogOutput     string `yaml:"-"`
+	LogMaxSize    int    `yaml:"-"`
+	LogMaxBackups int    `yaml:"-"`
+	// How long running requests may take to finish on shutdown
+	ShutdownTimeout time.Duration `yaml:"-"`
+	StaticContent   fs.FS         `yaml:"-"`
+
+	// Set by the configuration file, see LoadFile
+	StatusInterval time.Duration    `yaml:"status_interval"`
+	Prometheus     PrometheusConfig `yaml:"prometheus"`
+	InfluxDB       *InfluxDBConfig  `yaml:"influxdb"`
+	Nodes          []NodeConfig     `yaml:"nodes"`
+	Announcements  []Announcement   `yaml:"announcements"`
 }
 
 func NewServer(config Config) (Server, error) {
 	gin.SetMode(config.GinMode)
 
-	css, err := config.StaticContent.ReadFile("css/main.css")
+	css, err := fs.ReadFile(config.StaticContent, "css/main.css")
 	if err != nil {
 		panic(err)
 	}
 
-	chartCSS, err := config.StaticContent.ReadFile("css/chart.css")
+	chartCSS, err := fs.ReadFile(config.StaticContent, "css/chart.css")
 	if err != nil {
 		panic(err)
 	}
 
+	if err := config.validate(); err != nil {
+		return Server{}, err
+	}
+	metrics := newSelfMetrics(config)
+	logger, err := newLogger(config)
+	if err != nil {
+		return Server{}, err
+	}
+
+	metricsSources := make(map[string]MetricsSource)
+	prometheusSource, err := newPrometheusSource(config.Prometheus, logger)
+	if err != nil {
+		return Server{}, err
+	}
+	metricsSources[MetricsSourcePrometheus] = metricsSource{prometheusSource, MetricsSourcePrometheus, metrics}
+	if config.InfluxDB != nil {
+		influxDBSource, err := newInfluxDBSource(*config.InfluxDB)
+		if err != nil {
+			return Server{}, err
+		}
+		metricsSources[MetricsSourceInfluxDB] = metricsSource{influxDBSource, MetricsSourceInfluxDB, metrics}
+	}
+
 	s := Server{
-		Router:    gin.Default(),
+		Router:    gin.New(),
 		store:     persistence.NewInMemoryStore(time.Minute),
 		css:       template.CSS(css),
 		chartCSS:  string(chartCSS),
 		config:    config,
 		startTime: time.Now(),
+		logger:    logger,
+
+		metricsSources: metricsSources,
+		metrics:        metrics,
 	}
 
-	err = s.Router.SetTrustedProxies([]string{config.TrustedProxy})
+	// Peers of unix sockets are always trusted, see trustUnixPeers
+	err = s.Router.SetTrustedProxies([]string{config.TrustedProxy, unixPeerIP})
 	if err != nil {
 		panic(err)
 	}
@@ -77,6 +138,9 @@ func NewServer(config Config) (Server, error) {
 		base64.StdEncoding.EncodeToString(chartCSSSha256[:]),
 	}
 
+	// Before the recovery, to count and log panics as errors
+	s.Router.Use(s.accessLogHandler())
+	s.Router.Use(metrics.requestHandler())
 	s.Router.Use(nice.Recovery(s.recoveryHandler))
 
 	s.Router.Use(s.secureHandler(s.getSecureMiddleware()))
@@ -101,7 +165,7 @@ func NewServer(config Config) (Server, error) {
 	s.Router.StaticFS("/img", http.FS(imgRoot))
 
 	s.Router.GET("/robots.txt", func(c *gin.Context) {
-		c.String(http.StatusOK, "User-agent: *\nDisallow: /status\nDisallow: /status-*.svg")
+		c.String(http.StatusOK, "User-agent: *\nDisallow: /status\nDisallow: /status-*.svg\nDisallow: /api/\nDisallow: /chart/\nDisallow: /compare/\nDisallow: /badge/\nDisallow: /metrics")
 	})
 
 	s.Router.GET("/favicon.ico", func(c *gin.Context) {
@@ -109,14 +173,45 @@ func NewServer(config Config) (Server, error) {
 	})
 
 	s.Router.GET("/", s.cacheHandler(true, false, s.store, 10*time.Minute, s.handlerIndex))
-	s.Router.GET("/status", s.cacheHandler(true, false, s.store, time.Minute, s.handlerStatus))
 
-	for _, node := range [][2]string{{"hive", "hive.hashworks.net"}, {"helios", "helios.kromlinger.eu"}} {
-		for _, dimension := range svgLoadDimensions {
-			s.Router.GET(fmt.Sprintf("/load-%s-%dx%d.svg", node[0], dimension[0], dimension[1]), s.cacheHandler(true, false, s.store, 10*time.Minute, s.handlerLoadSVG(node[1], dimension[0], dimension[1])))
+	s.announcements, err = newAnnouncements(config)
+	if err != nil {
+		return Server{}, err
+	}
+
+	if config.StateDir != "" {
+		s.history, err = openHistory(config.StateDir)
+		if err != nil {
+			return Server{}, err
 		}
 	}
 
+	// The status is collected in the background, no need to cache it
+	s.startCollector()
+	s.Router.GET("/status", s.handlerStatus)
+	s.Router.GET("/api/v1/status", s.handlerAPIStatus)
+	s.Router.GET("/api/v1/status/:node", s.handlerAPINodeStatus)
+	s.Router.GET("/badge/:node/:service", s.cacheHandler(true, false, s.store, s.config.StatusInterval, s.handlerBadge))
+
+	// The admin endpoints are disabled without a token
+	if config.AdminToken != "" {
+		admin := s.Router.Group("/admin", s.adminAuthHandler())
+		admin.GET("/announcements", s.handlerAdminAnnouncements)
+		admin.POST("/announcements", s.handlerAdminAnnouncementCreate)
+		admin.DELETE("/announcements/:id", s.handlerAdminAnnouncementDelete)
+	}
+
+	// Query parameters select things like the mountpoint, they are part of the cache key
+	for _, node := range config.Nodes {
+		s.Router.GET("/load-"+node.Name+".svg", withQueryParams(chartQueryParams, s.cacheHandler(false, false, s.store, 10*time.Minute, s.handlerLoadSVG(node))))
+	}
+	s.Router.GET("/chart/:node/:chart", withQueryParams(chartQueryParams, s.cacheHandler(false, false, s.store, 10*time.Minute, s.handlerChart)))
+	s.Router.GET("/compare/:chart", withQueryParams(chartQueryParams, s.cacheHandler(false, false, s.store, 10*time.Minute, s.handlerCompare)))
+
+	if config.MetricsAddress == "" {
+		s.Router.GET("/metrics", gin.WrapH(s.MetricsHandler()))
+	}
+
 	s.Router.NoRoute(s.cacheHandler(true, false, s.store, 10*time.Minute, func(c *gin.Context) {
 		c.Header("Cache-Control", "max-age=600")
 		c.HTML(http.StatusNotFound, "error404", gin.H{
@@ -126,3 +221,13 @@ func NewServer(config Config) (Server, error) {
 
 	return s, nil
 }
+
+// Logger returns the logger of the access log, configured by LogOutput
+func (s Server) Logger() *slog.Logger {
+	return s.logger
+}
+
+// MetricsHandler serves the metrics of this server in the Prometheus format
+func (s Server) MetricsHandler() http.Handler {
+	return s.metrics.handler()
+}
@@ -12,28 +12,54 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-var influxAddressLoadData,
-	influxAddressLoadDataWarning,
-	influxAddressLoadDataError,
-
-	influxAddressStatusData,
-
-	influxAddressNoData,
-	influxAddressFailure,
-	influxAddressUnauthorized string
+// The generated css, templates and images of the repository root
+var staticContent = os.DirFS("..")
 
 func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
+// newTestServer returns a server of config with the settings all tests
+// share, closed once the test finished
+func newTestServer(t *testing.T, config Config) Server {
+	t.Helper()
+	s, err := NewServer(testConfig(config))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// testConfig adds the settings all tests share to config
+func testConfig(config Config) Config {
+	config.GinMode = gin.TestMode
+	config.StaticContent = staticContent
+	if config.TrustedProxy == "" {
+		config.TrustedProxy = "127.0.0.1"
+	}
+	return config
+}
+
+// get answers a GET request of path with the router
+func (s *Server) get(path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	s.Router.ServeHTTP(w, req)
+	return w
+}
+
 func TestBasicParallel(t *testing.T) {
 	s, err := NewServer(Config{
 		TLSProxy:      true,
 		GinMode:       gin.TestMode,
 		Debug:         true,
 		GZIPExtension: true,
+		TrustedProxy:  "127.0.0.1",
+		StaticContent: staticContent,
 	})
 	assert.NoError(t, err)
+	defer s.Close()
 
 	t.Run("basicTests", func(t *testing.T) {
 		t.Run("header", s.headerTest)
@@ -118,10 +144,13 @@ func (s *Server) robotsTest(t *testing.T) {
 
 func TestNoDebugCSS(t *testing.T) {
 	s, err := NewServer(Config{
-		GinMode: gin.TestMode,
-		Debug:   false,
+		GinMode:       gin.TestMode,
+		Debug:         false,
+		TrustedProxy:  "127.0.0.1",
+		StaticContent: staticContent,
 	})
 	assert.NoError(t, err)
+	defer s.Close()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/", nil)
 	s.Router.ServeHTTP(w, req)
@@ -133,12 +162,15 @@ func TestNoDebugCSS(t *testing.T) {
 
 func TestWrongHost(t *testing.T) {
 	s, err := NewServer(Config{
-		Domain:   "test.example.de",
-		TLSProxy: true,
-		GinMode:  gin.TestMode,
-		Debug:    true,
+		Domain:        "test.example.de",
+		TLSProxy:      true,
+		GinMode:       gin.TestMode,
+		Debug:         true,
+		TrustedProxy:  "127.0.0.1",
+		StaticContent: staticContent,
 	})
 	assert.NoError(t, err)
+	defer s.Close()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/", nil)
 	s.Router.ServeHTTP(w, req)
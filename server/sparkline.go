@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sparklines are drawn at their size, they are too small to scale
+const (
+	sparklineWidth  = 100
+	sparklineHeight = 20
+	// Points are averaged to keep the data URIs small
+	sparklinePoints = 50
+	// A day of samples barely changes in this time, so the collector doesn't
+	// query it every interval
+	sparklineExpiry = 10 * time.Minute
+)
+
+var sparklineRange = chartRange{"24h", 24 * time.Hour, "24 hours", "15:04"}
+
+// Sparkline is a tiny chart of a service, inlined as data URI
+type Sparkline struct {
+	URL template.URL
+	Alt string
+}
+
+// sparklineSVG draws samples as a line colored by status between 0 and max
+func sparklineSVG(samples []Sample, max float64, status string) template.URL {
+	samples = downsample(samples, sparklinePoints)
+	first, last := samples[0].Time, samples[len(samples)-1].Time
+
+	points := make([]string, len(samples))
+	for i, sample := range samples {
+		x := float64(sparklineWidth) * float64(sample.Time.Sub(first)) / float64(last.Sub(first))
+		// Keep a pixel to the edges, so the line isn't cut off
+		y := sparklineHeight - 1 - sample.Value/max*(sparklineHeight-2)
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+
+	// Images can't be styled with the CSS of the page
+	color := chartStatusColors[status]
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<polyline fill="none" stroke="#%02x%02x%02x" stroke-width="1.5" points="%s"/></svg>`,
+		sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight, color.R, color.G, color.B, strings.Join(points, " "))
+
+	return template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg)))
+}
+
+// downsample averages samples into at most n buckets of equal length
+func downsample(samples []Sample, n int) []Sample {
+	if len(samples) <= n {
+		return samples
+	}
+
+	var downsampled []Sample
+	for i := 0; i < n; i++ {
+		bucket := samples[i*len(samples)/n : (i+1)*len(samples)/n]
+		var sum float64
+		for _, sample := range bucket {
+			sum += sample.Value
+		}
+		downsampled = append(downsampled, Sample{bucket[0].Time, sum / float64(len(bucket))})
+	}
+	return downsampled
+}
+
+// serviceSparkline draws the latency of a probe or the utilisation of an SNMP
+// service in the last 24 hours
+func (s *Server) serviceSparkline(ctx context.Context, nodeConfig NodeConfig, serviceConfig ServiceConfig) (Sparkline, bool) {
+	metric := chartMetrics["latency"]
+	if serviceConfig.Type == ServiceTypeSNMP {
+		metric = chartMetrics["utilisation"]
+	}
+
+	series, err := metric.nodeSeries(nodeConfig, url.Values{"service": {serviceConfig.Name}})
+	if err != nil {
+		return Sparkline{}, false
+	}
+	samples, capacities, err := s.chartSamples(ctx, series, sparklineRange)
+	if err != nil || len(samples[0]) < 2 {
+		return Sparkline{}, false
+	}
+
+	summary := summarize(metric.Title, samples[0])
+	max := capacities[0]
+	if max == 0 {
+		max = summary.Max
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	return Sparkline{
+		URL: sparklineSVG(samples[0], max, metric.status(summary.Last, capacities[0])),
+		Alt: fmt.Sprintf("%s in the last %s: %s.", metric.Title, sparklineRange.Label, describeTrend(samples[0], metric.Formatter)),
+	}, true
+}
+
+// serviceSparklines returns the sparklines of all services by "node/service".
+// Services without enough samples have none until a later collection.
+func (s *Server) serviceSparklines(ctx context.Context) map[string]Sparkline {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	sparklines := make(map[string]Sparkline)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, nodeConfig := range s.config.Nodes {
+		for _, serviceConfig := range nodeConfig.Services {
+			wg.Add(1)
+			go func(nodeConfig NodeConfig, serviceConfig ServiceConfig) {
+				defer wg.Done()
+
+				key := nodeConfig.Name + "/" + serviceConfig.Name
+				var sparkline Sparkline
+				if s.config.Debug || !s.cacheGet("sparkline", key, &sparkline) {
+					var ok bool
+					if sparkline, ok = s.serviceSparkline(ctx, nodeConfig, serviceConfig); !ok {
+						return
+					}
+					s.cacheSet("sparkline", key, sparkline, sparklineExpiry)
+				}
+
+				mutex.Lock()
+				sparklines[key] = sparkline
+				mutex.Unlock()
+			}(nodeConfig, serviceConfig)
+		}
+	}
+	wg.Wait()
+	return sparklines
+}
@@ -1,8 +1,8 @@
 package server
 
 import (
-	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -22,16 +22,28 @@ func (s Server) recoveryHandlerStatus(statusCode int, c *gin.Context, err interf
 		message = "Unknown"
 	}
 
-	log.Printf("%s - Error: %s", timeString, message)
+	s.logger.Error(message, "request_id", requestID(c), "status", statusCode)
 
 	if !s.config.Debug {
 		message = "There was an error, please report this to mail@hashworks.net."
 	}
 
 	c.AbortWithStatusJSON(statusCode, map[string]interface{}{
-		"time":   timeString,
-		"error":  message,
-		"status": statusCode,
+		"time":       timeString,
+		"error":      message,
+		"status":     statusCode,
+		"request_id": requestID(c),
+	})
+}
+
+// errorJSON responds like recoveryHandlerStatus, but always includes the
+// message, since it is meant for errors caused by the client
+func errorJSON(c *gin.Context, statusCode int, err error) {
+	c.AbortWithStatusJSON(statusCode, map[string]interface{}{
+		"time":       time.Now().Format(time.RFC3339),
+		"error":      err.Error(),
+		"status":     statusCode,
+		"request_id": requestID(c),
 	})
 }
 
@@ -64,13 +76,48 @@ func (s Server) preHandler() gin.HandlerFunc {
 	}
 }
 
+const cacheMissKey = "cacheMiss"
+
+// withQueryParams drops all but the first value of the given query parameters
+// before calling handle. The others can't add entries to the page cache then.
+func withQueryParams(params []string, handle gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		accepted := url.Values{}
+		for _, param := range params {
+			if value := query.Get(param); value != "" {
+				accepted.Set(param, value)
+			}
+		}
+		// Sorted by key, so the order doesn't matter either
+		c.Request.URL.RawQuery = accepted.Encode()
+		handle(c)
+	}
+}
+
 func (s Server) cacheHandler(withoutQuery bool, withoutHeader bool, store persistence.CacheStore, expire time.Duration, handle gin.HandlerFunc) gin.HandlerFunc {
 	// No cache in debug mode
 	if s.config.Debug {
 		return handle
 	}
+	// The handler is only called on a miss, the store is read for every
+	// write of a response and can't tell
+	miss := func(c *gin.Context) {
+		c.Set(cacheMissKey, true)
+		handle(c)
+	}
+	cached := cache.CachePage(store, expire, miss)
 	if withoutQuery {
-		return cache.CachePageWithoutQuery(store, expire, handle)
+		cached = cache.CachePageWithoutQuery(store, expire, miss)
+	}
+	return func(c *gin.Context) {
+		cached(c)
+		hit := !c.GetBool(cacheMissKey)
+		s.metrics.cacheRequest("page", hit)
+		if hit {
+			c.Set(cacheResultKey, "hit")
+		} else {
+			c.Set(cacheResultKey, "miss")
+		}
 	}
-	return cache.CachePage(store, expire, handle)
 }
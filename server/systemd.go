@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// First file descriptor passed by systemd and handoff, after stdin, stdout
+// and stderr
+const listenFDsStart = 3
+
+// systemdListeners returns the sockets passed by systemd socket activation,
+// keyed by their FileDescriptorName. Unnamed sockets serve the router.
+func systemdListeners() (map[string]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Processes started by this one get their listeners by handoff
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	files := make([]*os.File, 0, count)
+	for i := 0; i < count; i++ {
+		name := listenerHTTP
+		if i < len(names) && names[i] != "" && names[i] != "unknown" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), name))
+	}
+	return listenersFromFiles(files)
+}
+
+// sdNotify sends a state like READY=1 to systemd, if started by it with
+// Type=notify
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets start with a null byte
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns half of the WatchdogSec of the unit, zero if
+// disabled or meant for another process. handoff doesn't pass WATCHDOG_PID on,
+// the new process takes over the watchdog of its parent.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// watchdog pings the systemd watchdog as long as the status is collected and
+// reports whether Prometheus is reachable. An unreachable Prometheus doesn't
+// stop the pings, a restart of this server wouldn't help.
+func (s Server) watchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.healthy(time.Now()) {
+			s.logger.Error("status collection is stuck, skipping the watchdog")
+			continue
+		}
+
+		status := "STATUS=Prometheus is reachable."
+		if err := s.prometheusReachable(ctx); err != nil {
+			status = "STATUS=Prometheus is unreachable: " + err.Error()
+		}
+		if err := sdNotify("WATCHDOG=1\n" + status); err != nil {
+			s.logger.Error(err.Error())
+		}
+	}
+}
+
+// healthy is false if the status collection didn't finish in time, which
+// is bounded by the timeouts of the metrics sources. A stopped collection
+// isn't stuck, handoff stops it until the new process is ready.
+func (s Server) healthy(now time.Time) bool {
+	s.collector.RLock()
+	defer s.collector.RUnlock()
+
+	select {
+	case <-s.collector.done:
+		return true
+	default:
+	}
+
+	// Wait for the first snapshot as long as for a later one
+	last := s.collector.snapshot.Time
+	if last.IsZero() {
+		last = s.startTime
+	}
+	return now.Sub(last) < 2*s.config.StatusInterval+2*s.config.Prometheus.Timeout
+}
+
+func (s Server) prometheusReachable(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Prometheus.Timeout)
+	defer cancel()
+
+	// up matches a series per target, which is still an answer
+	_, err := s.metricsSources[MetricsSourcePrometheus].Instant(ctx, MetricQuery{Metric: "up"})
+	if errors.Is(err, ErrNoData) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noDataSource answers every instant query with a wrapped ErrNoData
+type noDataSource struct {
+	MetricsSource
+}
+
+func (noDataSource) Instant(ctx context.Context, query MetricQuery) (float64, error) {
+	return 0, fmt.Errorf("query failed: %w", ErrNoData)
+}
+
+// listenNotify receives the messages of sdNotify, the returned function
+// reads the next one
+func listenNotify(t *testing.T) func() string {
+	socket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "notify"), Net: "unixgram"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = socket.Close() })
+	_ = socket.SetReadDeadline(time.Now().Add(5 * time.Second))
+	t.Setenv("NOTIFY_SOCKET", socket.LocalAddr().String())
+
+	return func() string {
+		buffer := make([]byte, 1024)
+		n, err := socket.Read(buffer)
+		assert.NoError(t, err)
+		return string(buffer[:n])
+	}
+}
+
+func TestSystemd(t *testing.T) {
+	unreachable := PrometheusConfig{Address: "http://127.0.0.1:1", Timeout: time.Second}
+
+	t.Run("notify", func(t *testing.T) {
+		read := listenNotify(t)
+		assert.NoError(t, sdNotify("READY=1"))
+		assert.Equal(t, "READY=1", read())
+	})
+
+	t.Run("watchdog", func(t *testing.T) {
+		read := listenNotify(t)
+		t.Setenv("WATCHDOG_USEC", "100000")
+		assert.Equal(t, 50*time.Millisecond, watchdogInterval())
+		t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+		assert.Equal(t, 50*time.Millisecond, watchdogInterval())
+		// Meant for the parent, like a shell started by the server
+		t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getppid()))
+		assert.Zero(t, watchdogInterval())
+		t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+		// Pings continue without Prometheus, the status tells why
+		s := newTestServer(t, Config{Prometheus: unreachable})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.watchdog(ctx, watchdogInterval())
+		assert.Regexp(t, `^WATCHDOG=1\nSTATUS=Prometheus is unreachable: .*connection refused$`, read())
+	})
+
+	t.Run("healthy", func(t *testing.T) {
+		s := newTestServer(t, Config{Prometheus: unreachable})
+		assert.True(t, s.healthy(time.Now()))
+		assert.False(t, s.healthy(time.Now().Add(time.Hour)))
+
+		// Stopped while passing the listeners on
+		assert.NoError(t, s.Close())
+		assert.True(t, s.healthy(time.Now().Add(time.Hour)))
+	})
+
+	t.Run("reachable", func(t *testing.T) {
+		// Missing data is still an answer, even if wrapped
+		s := newTestServer(t, Config{Prometheus: unreachable})
+		s.metricsSources[MetricsSourcePrometheus] = noDataSource{}
+		assert.NoError(t, s.prometheusReachable(context.Background()))
+	})
+
+	t.Run("listeners", func(t *testing.T) {
+		// Not started by systemd
+		listeners, err := systemdListeners()
+		assert.NoError(t, err)
+		assert.Nil(t, listeners)
+	})
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"html/template"
+	"io/fs"
 	"runtime"
 	"strings"
 	"time"
@@ -34,7 +35,7 @@ func (s Server) templateFunctionMap() template.FuncMap {
 
 func (s Server) loadTemplates() {
 	// Load template file names from Asset
-	templateDirEntries, err := s.config.StaticContent.ReadDir("templates")
+	templateDirEntries, err := fs.ReadDir(s.config.StaticContent, "templates")
 	if err != nil {
 		panic(err)
 	}
@@ -51,7 +52,7 @@ func (s Server) loadTemplates() {
 		index = strings.Index(basename, ".")
 		basename = basename[:index]
 		tmpl := tmpl.New(basename)
-		data, err := s.config.StaticContent.ReadFile("templates/" + templateDirEntry.Name())
+		data, err := fs.ReadFile(s.config.StaticContent, "templates/"+templateDirEntry.Name())
 		if err != nil {
 			panic(err)
 		}
@@ -62,5 +63,5 @@ func (s Server) loadTemplates() {
 		multiT.Add(basename, tmpl)
 	}
 	// multitemplate is our new HTML renderer
-	s.Router.HTMLRender = multiT
+	s.Router.HTMLRender = metricsHTMLRender{multiT, s.metrics}
 }
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes
+const tlsReloadInterval = time.Minute
+
+// certificateStore serves the certificate of the TLSCert and TLSKey files
+// and reloads it once they or the OCSP staple change
+type certificateStore struct {
+	sync.RWMutex
+	certFile, keyFile, ocspFile string
+
+	certificate *tls.Certificate
+	modTimes    []time.Time
+}
+
+func newCertificateStore(config Config) (*certificateStore, error) {
+	c := &certificateStore{certFile: config.TLSCert, keyFile: config.TLSKey, ocspFile: config.TLSOCSPStaple}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certificateStore) files() []string {
+	files := []string{c.certFile, c.keyFile}
+	if c.ocspFile != "" {
+		files = append(files, c.ocspFile)
+	}
+	return files
+}
+
+func (c *certificateStore) fileModTimes() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func (c *certificateStore) load() error {
+	modTimes, err := c.fileModTimes()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	// A DER encoded OCSP response, renewed by a cron job like the certificate
+	if c.ocspFile != "" {
+		certificate.OCSPStaple, err = os.ReadFile(c.ocspFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.certificate = &certificate
+	c.modTimes = modTimes
+	return nil
+}
+
+// changed is true if any file was modified since the last load
+func (c *certificateStore) changed() bool {
+	modTimes, err := c.fileModTimes()
+	if err != nil {
+		// Files are replaced by renaming, try again later
+		return false
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	for i := range modTimes {
+		if !modTimes[i].Equal(c.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *certificateStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.certificate, nil
+}
+
+// watchCertificate reloads the certificate after changes or once reload
+// receives a signal, keeping the old one if the new files are invalid
+func (s Server) watchCertificate(ctx context.Context, certificates *certificateStore, reload <-chan os.Signal) {
+	ticker := time.NewTicker(tlsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !certificates.changed() {
+				continue
+			}
+		case <-reload:
+		}
+
+		if err := certificates.load(); err != nil {
+			s.logger.Error("reloading the certificate failed: " + err.Error())
+			continue
+		}
+		s.logger.Info("reloaded the certificate", "file", certificates.certFile)
+	}
+}
+
+// tlsConfig allows TLS 1.2 with forward secret AEAD ciphers and TLS 1.3,
+// whose ciphers aren't configurable
+func tlsConfig(certificates *certificateStore) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetCertificate:   certificates.getCertificate,
+	}
+}
+
+// redirectHandler redirects every request to the same URL with HTTPS on the
+// port of the HTTPS listener, which may differ from the configured address
+// if passed by systemd
+func (s Server) redirectHandler(httpsAddr net.Addr) http.Handler {
+	port := ""
+	if _, p, err := net.SplitHostPort(httpsAddr.String()); err == nil && p != "443" {
+		port = p
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := s.config.Domain
+		if host == "" {
+			host = r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCertificate writes a self-signed certificate of name and its key
+func writeTestCertificate(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+// testTLSConfig writes a certificate of old.example.com with an OCSP staple
+// and returns a configuration serving it
+func testTLSConfig(t *testing.T) Config {
+	dir := t.TempDir()
+	config := Config{
+		TLSCert:       filepath.Join(dir, "cert.pem"),
+		TLSKey:        filepath.Join(dir, "key.pem"),
+		TLSOCSPStaple: filepath.Join(dir, "ocsp.der"),
+	}
+	writeTestCertificate(t, config.TLSCert, config.TLSKey, "old.example.com")
+	assert.NoError(t, os.WriteFile(config.TLSOCSPStaple, []byte("staple"), 0600))
+	return config
+}
+
+func TestTLS(t *testing.T) {
+	s := newTestServer(t, testTLSConfig(t))
+	certificates, err := newCertificateStore(s.config)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	redirectListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+
+	signals, served := startServe(s, []namedListener{
+		{name: listenerHTTP, address: address, listener: listener, handler: s.Router, tlsConfig: tlsConfig(certificates)},
+		{name: listenerRedirect, address: redirectListener.Addr().String(), listener: redirectListener, handler: s.redirectHandler(listener.Addr())},
+	})
+	defer func() {
+		signals <- syscall.SIGTERM
+		assert.NoError(t, <-served)
+	}()
+
+	client := http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	t.Run("https", func(t *testing.T) {
+		resp, err := client.Get("https://" + address + "/")
+		if !assert.NoError(t, err) {
+			return
+		}
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "old.example.com", resp.TLS.PeerCertificates[0].Subject.CommonName)
+		assert.Equal(t, []byte("staple"), resp.TLS.OCSPResponse)
+		assert.Contains(t, resp.Header.Get("Strict-Transport-Security"), "max-age=315360000")
+	})
+
+	t.Run("ciphers", func(t *testing.T) {
+		// Ciphers without forward secrecy are refused
+		_, err := tls.Dial("tcp", address, &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+			CipherSuites:       []uint16{tls.TLS_RSA_WITH_AES_128_GCM_SHA256},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		_, port, _ := net.SplitHostPort(address)
+		_, redirectPort, _ := net.SplitHostPort(redirectListener.Addr().String())
+		resp, err := client.Get("http://localhost:" + redirectPort + "/status?range=7d")
+		if !assert.NoError(t, err) {
+			return
+		}
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+		assert.Equal(t, "https://localhost:"+port+"/status?range=7d", resp.Header.Get("Location"))
+	})
+}
+
+func TestRedirectPort(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	for _, test := range []struct {
+		name     string
+		addr     net.Addr
+		location string
+	}{
+		{"default port", &net.TCPAddr{IP: net.IPv6zero, Port: 443}, "https://example.com/status"},
+		{"other port", &net.TCPAddr{IP: net.IPv6zero, Port: 8443}, "https://example.com:8443/status"},
+		{"unix socket", &net.UnixAddr{Name: "/run/hashworksNET/https.sock", Net: "unix"}, "https://example.com/status"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.redirectHandler(test.addr).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com:8080/status", nil))
+			assert.Equal(t, http.StatusMovedPermanently, w.Code)
+			assert.Equal(t, test.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	config := testTLSConfig(t)
+	s := newTestServer(t, config)
+	certificates, err := newCertificateStore(s.config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	served := func() string {
+		certificate, err := certificates.getCertificate(nil)
+		assert.NoError(t, err)
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		assert.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+
+	// Replaced certificates are served without a restart
+	assert.False(t, certificates.changed())
+	writeTestCertificate(t, config.TLSCert, config.TLSKey, "new.example.com")
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(config.TLSCert, future, future))
+	assert.True(t, certificates.changed())
+	assert.NoError(t, certificates.load())
+	assert.Equal(t, "new.example.com", served())
+
+	// SIGUSR1 reloads them without waiting for the next check
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := make(chan os.Signal, 1)
+	go s.watchCertificate(ctx, certificates, reload)
+	writeTestCertificate(t, config.TLSCert, config.TLSKey, "signal.example.com")
+	reload <- syscall.SIGUSR1
+	assert.Eventually(t, func() bool {
+		return served() == "signal.example.com"
+	}, time.Second, 10*time.Millisecond)
+
+	// Invalid ones are not
+	assert.NoError(t, os.WriteFile(config.TLSKey, []byte("invalid"), 0600))
+	assert.Error(t, certificates.load())
+	assert.Equal(t, "signal.example.com", served())
+}
+
+func TestTLSSettings(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{"certificate without key", Config{TLSCert: "cert.pem"}, "a TLS certificate requires a key and the other way around"},
+		{"key without certificate", Config{TLSKey: "key.pem"}, "a TLS certificate requires a key and the other way around"},
+		{"staple without certificate", Config{TLSOCSPStaple: "ocsp.der"}, "OCSP stapling and the HTTPS redirect require a TLS certificate"},
+		{"redirect without certificate", Config{TLSRedirectAddress: ":80"}, "OCSP stapling and the HTTPS redirect require a TLS certificate"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewServer(testConfig(test.config))
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}